@@ -0,0 +1,146 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/warik/go-dialer/conf"
+)
+
+const (
+	INFO     = "info"
+	WARNING  = "warning"
+	CRITICAL = "critical"
+)
+
+var (
+	SEVERITIES = map[string]int{INFO: 0, WARNING: 1, CRITICAL: 2}
+
+	once   sync.Once
+	queue  chan Alert
+	active = struct {
+		sync.Mutex
+		// Time when alert with the key was sent the last time
+		m map[string]time.Time
+	}{m: map[string]time.Time{}}
+	limiter = struct {
+		sync.Mutex
+		sent []time.Time
+	}{}
+)
+
+type Alert struct {
+	Key       string    `json:"key"`
+	Severity  string    `json:"severity"`
+	Message   string    `json:"message"`
+	Recovered bool      `json:"recovered"`
+	Dialer    string    `json:"dialer"`
+	Time      time.Time `json:"time"`
+}
+
+func (a Alert) String() string {
+	state := strings.ToUpper(a.Severity)
+	if a.Recovered {
+		state = "RECOVERED"
+	}
+	return fmt.Sprintf("[%s] %s: %s", state, a.Dialer, a.Message)
+}
+
+// Raise sends alert about problem identified by key. While the problem is not
+// resolved, the same alert is sent again only after dedup interval
+func Raise(key, severity, message string) {
+	now := time.Now()
+	active.Lock()
+	sentAt, ok := active.m[key]
+	if ok && now.Sub(sentAt) < conf.GetConf().GetAlertDedupInterval() {
+		active.Unlock()
+		glog.Warningln("Alert is suppressed as duplicate", key, message)
+		return
+	}
+	active.m[key] = now
+	active.Unlock()
+
+	if send(Alert{Key: key, Severity: severity, Message: message}) {
+		return
+	}
+	// Dropped alert was not received by anybody, so it is neither duplicate
+	// nor should be resolved
+	active.Lock()
+	if active.m[key] == now {
+		if ok {
+			active.m[key] = sentAt
+		} else {
+			delete(active.m, key)
+		}
+	}
+	active.Unlock()
+}
+
+// Resolve sends recovery notification if there was alert with the key
+func Resolve(key, message string) {
+	active.Lock()
+	_, ok := active.m[key]
+	delete(active.m, key)
+	active.Unlock()
+	if ok {
+		send(Alert{Key: key, Severity: INFO, Message: message, Recovered: true})
+	}
+}
+
+// send puts alert to queue, alerts are sent by alerters in background so callers
+// are never blocked. Alerts over rate limit or queue size are dropped, false is
+// returned for them
+func send(a Alert) bool {
+	once.Do(start)
+	a.Dialer, a.Time = conf.GetConf().Name, time.Now().UTC()
+	if !allow(a.Time) {
+		glog.Warningln("Alert is dropped by rate limit", a)
+		return false
+	}
+	select {
+	case queue <- a:
+		return true
+	default:
+		glog.Warningln("Alerts queue is full, alert is dropped", a)
+		return false
+	}
+}
+
+// allow tells if one more alert fits into limit of alerts per minute
+func allow(now time.Time) bool {
+	limiter.Lock()
+	defer limiter.Unlock()
+	sent := limiter.sent[:0]
+	for _, t := range limiter.sent {
+		if now.Sub(t) < time.Minute {
+			sent = append(sent, t)
+		}
+	}
+	limiter.sent = sent
+	if len(sent) >= conf.GetConf().GetAlertsPerMinute() {
+		return false
+	}
+	limiter.sent = append(limiter.sent, now)
+	return true
+}
+
+func start() {
+	queue = make(chan Alert, conf.ALERTS_QUEUE_SIZE)
+	go func() {
+		for a := range queue {
+			glog.Warningln("<<< ALERT", a)
+			for _, alerter := range GetAlerters() {
+				if SEVERITIES[a.Severity] < SEVERITIES[alerter.MinSeverity()] && !a.Recovered {
+					continue
+				}
+				if err := alerter.Send(a); err != nil {
+					glog.Errorln("Cannot send alert", alerter.Name(), err)
+				}
+			}
+		}
+	}()
+}
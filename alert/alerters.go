@@ -0,0 +1,214 @@
+package alert
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/parnurzeal/gorequest"
+
+	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/model"
+)
+
+const (
+	SMS     = "sms"
+	WEBHOOK = "webhook"
+	SMTP    = "smtp"
+	SLACK   = "slack"
+)
+
+// Alerter sends alerts to one channel
+type Alerter interface {
+	Name() string
+	MinSeverity() string
+	Send(a Alert) error
+}
+
+// GetAlerters returns alerters of channels from current configuration. If there
+// are no channels, alerts are sent by sms to alert phones as before
+func GetAlerters() []Alerter {
+	channels := conf.GetConf().Alerts
+	if len(channels) == 0 {
+		channels = []model.AlertChannel{{Type: SMS}}
+	}
+	alerters := []Alerter{}
+	for _, channel := range channels {
+		switch channel.Type {
+		case SMS:
+			if !*conf.SmsAlerts {
+				continue
+			}
+			alerters = append(alerters, SMSAlerter{channel})
+		case WEBHOOK:
+			alerters = append(alerters, WebhookAlerter{channel})
+		case SMTP:
+			alerters = append(alerters, SMTPAlerter{channel})
+		case SLACK:
+			alerters = append(alerters, SlackAlerter{channel})
+		default:
+			glog.Errorln("Unknown alert channel", channel.Type)
+		}
+	}
+	return alerters
+}
+
+type SMSAlerter struct {
+	model.AlertChannel
+}
+
+func (s SMSAlerter) Name() string {
+	return SMS
+}
+
+func (s SMSAlerter) MinSeverity() string {
+	return s.AlertChannel.MinSeverity
+}
+
+func (s SMSAlerter) Send(a Alert) error {
+	gateway := s.Url
+	if gateway == "" {
+		gateway = conf.SMS_GATEWAY_URL
+	}
+	phones := s.Recipients
+	if len(phones) == 0 {
+		phones = conf.GetConf().GetAlertPhones()
+	}
+	// Failure of one phone does not stop alert from reaching the others
+	failed := []string{}
+	for _, phone := range phones {
+		params := url.Values{
+			"login":     {s.Login},
+			"passwd":    {s.Password},
+			"destaddr":  {phone},
+			"msgchrset": {"cyr"},
+			"msgtext":   {a.String()},
+		}
+		resp, _, errs := gorequest.New().Timeout(conf.REQUEST_TIMEOUT * time.Second).
+			Get(gateway).Query(params.Encode()).End()
+		if len(errs) != 0 {
+			failed = append(failed, fmt.Sprintf("%s: %v", phone, errs[0]))
+		} else if resp.StatusCode >= 300 {
+			failed = append(failed, fmt.Sprintf("%s: "+conf.REMOTE_ERROR_TEXT, phone, resp.StatusCode))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("Sms is not sent to %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// WebhookAlerter posts alert as json
+type WebhookAlerter struct {
+	model.AlertChannel
+}
+
+func (w WebhookAlerter) Name() string {
+	return WEBHOOK
+}
+
+func (w WebhookAlerter) MinSeverity() string {
+	return w.AlertChannel.MinSeverity
+}
+
+func (w WebhookAlerter) Send(a Alert) error {
+	return postJSON(w.Url, a)
+}
+
+// SlackAlerter posts alert to slack compatible incoming webhook
+type SlackAlerter struct {
+	model.AlertChannel
+}
+
+func (s SlackAlerter) Name() string {
+	return SLACK
+}
+
+func (s SlackAlerter) MinSeverity() string {
+	return s.AlertChannel.MinSeverity
+}
+
+func (s SlackAlerter) Send(a Alert) error {
+	return postJSON(s.Url, model.Dict{"text": a.String()})
+}
+
+// SMTPAlerter sends alert by email, Url is address of smtp server like host:587
+type SMTPAlerter struct {
+	model.AlertChannel
+}
+
+func (s SMTPAlerter) Name() string {
+	return SMTP
+}
+
+func (s SMTPAlerter) MinSeverity() string {
+	return s.AlertChannel.MinSeverity
+}
+
+// Send does the same as smtp.SendMail, but with deadline, so hung smtp server
+// does not block other alerts
+func (s SMTPAlerter) Send(a Alert) error {
+	host := strings.Split(s.Url, ":")[0]
+	conn, err := net.DialTimeout("tcp", s.Url, conf.REQUEST_TIMEOUT*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(conf.SMTP_TIMEOUT))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Login != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Login, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, recipient := range s.Recipients {
+		if err = c.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		s.From, strings.Join(s.Recipients, ", "), a, a.Message)
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func postJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, _, errs := gorequest.New().Timeout(conf.REQUEST_TIMEOUT * time.Second).
+		Post(url).Type("json").Send(string(data)).End()
+	if len(errs) != 0 {
+		return errs[0]
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf(conf.REMOTE_ERROR_TEXT, resp.StatusCode)
+	}
+	return nil
+}
@@ -3,41 +3,172 @@ package ami
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/warik/gami"
 
+	"github.com/warik/go-dialer/alert"
 	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/logger"
+	"github.com/warik/go-dialer/metrics"
 	"github.com/warik/go-dialer/model"
 	"github.com/warik/go-dialer/util"
 )
 
 var (
-	QueueState = util.NewSafeMap()
-	once       sync.Once
-	ami        *gami.Asterisk
+	amiLog = logger.New("ami")
+
+	once    sync.Once
+	ami     *gami.Asterisk
+	amiLock sync.RWMutex
+	// Only one relogin is done at a time
+	reloginLock sync.Mutex
+
+	eventHandlers = struct {
+		sync.RWMutex
+		m           map[string][]func(gami.Message)
+		dispatchers map[string]*func(gami.Message)
+	}{m: map[string][]func(gami.Message){}, dispatchers: map[string]*func(gami.Message){}}
+	reconnectHandlers []func()
+
+	// State of connection, they are changed atomically
+	connected  int32
+	reconnects int32
+
+	// Events which come as a response to list actions, grouped by ActionID
+	eventLists = struct {
+		sync.Mutex
+		m          map[string]*eventList
+		registered map[string]struct{}
+	}{m: map[string]*eventList{}, registered: map[string]struct{}{}}
 )
 
+type eventList struct {
+	items    []gami.Message
+	complete bool
+	expected int
+	created  time.Time
+}
+
 func GetAMI() *gami.Asterisk {
 	once.Do(func() {
 		conf := conf.GetConf()
-		ami = startAmi(conf.AsteriskHost, conf.AMILogin, conf.AMIPassword)
+		a := startAmi(conf.AsteriskHost, conf.AMILogin, conf.AMIPassword)
+		amiLock.Lock()
+		ami = a
+		amiLock.Unlock()
 	})
+	amiLock.RLock()
+	defer amiLock.RUnlock()
 	return ami
 }
 
-func connectAndLogin(a *gami.Asterisk) {
+// ConfChanged logins to asterisk again if its address or credentials were changed
+func ConfChanged(old, new *conf.Configuration) {
+	if old.AsteriskHost == new.AsteriskHost && old.AMILogin == new.AMILogin &&
+		old.AMIPassword == new.AMIPassword {
+		return
+	}
+	if err := Relogin(); err != nil {
+		amiLog.Error("Cannot relogin to asterisk, old connection is kept", logger.Fields{"error": err})
+	}
+}
+
+// Relogin connects to asterisk with current credentials and replaces old
+// connection with the new one, event handlers are moved to the new connection.
+// If new connection is not established in AMI_RELOGIN_TIMEOUT, old one is kept
+func Relogin() error {
+	reloginLock.Lock()
+	defer reloginLock.Unlock()
+
+	amiLog.Warning("Relogin to asterisk")
+	settings := conf.GetConf()
+	a := gami.NewAsterisk(settings.AsteriskHost, settings.AMILogin, settings.AMIPassword)
+	if err := startWithTimeout(a, conf.AMI_RELOGIN_TIMEOUT); err != nil {
+		return err
+	}
+	a.SendAction(gami.Message{"Action": "Events", "EventMask": "cdr,call,agent,system"}, nil)
+	watchConnection(a)
+	eventHandlers.RLock()
+	for event, dispatch := range eventHandlers.dispatchers {
+		a.RegisterHandler(event, dispatch)
+	}
+	eventHandlers.RUnlock()
+
+	old := GetAMI()
+	amiLock.Lock()
+	ami = a
+	amiLock.Unlock()
+	old.Logoff()
+	metrics.AmiConnected.Set(1)
+	atomic.StoreInt32(&connected, 1)
+	alert.Resolve("ami_connection", "Connection with asterisk restored")
+	// Events could be missed while connections were switched
+	for _, h := range reconnectHandlers {
+		go h()
+	}
+	return nil
+}
+
+// startWithTimeout connects and logins to asterisk, connection which was not
+// established in time is closed as soon as it is established
+func startWithTimeout(a *gami.Asterisk, timeout time.Duration) error {
+	var lock sync.Mutex
+	abandoned := false
+	done := make(chan error, 1)
+	go func() {
+		err := a.Start()
+		lock.Lock()
+		defer lock.Unlock()
+		if abandoned {
+			if err == nil {
+				a.Logoff()
+			}
+			return
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		lock.Lock()
+		defer lock.Unlock()
+		abandoned = true
+		// Connection may be established right before timeout
+		select {
+		case err := <-done:
+			if err == nil {
+				a.Logoff()
+			}
+		default:
+		}
+		return errors.New("Timeout while connecting to asterisk")
+	}
+}
+
+// connectAndLogin connects and logins to asterisk until it succeeds. Functions
+// registered by OnReconnect are called if connection was restored after loss
+func connectAndLogin(a *gami.Asterisk, reconnect bool) {
 	messageAlreadySent := false
 	numTries := 1
 	for {
+		// Connection replaced by Relogin is not needed anymore
+		if isReplaced(a) {
+			return
+		}
 		if err := a.Start(); err != nil {
-			glog.Errorln(err)
-			glog.Warningln("Trying to reconnect and relogin...")
+			metrics.AmiConnected.Set(0)
+			atomic.StoreInt32(&connected, 0)
+			amiLog.Warning("Cannot connect to asterisk, trying to reconnect and relogin",
+				logger.Fields{"error": err, "attempt": numTries})
 			if !messageAlreadySent {
-				conf.Alert("Lost connection with asterisk")
+				alert.Raise("ami_connection", alert.CRITICAL, "Lost connection with asterisk")
 				messageAlreadySent = true
 			}
 			duration := util.PowInt(conf.AMI_RECONNECT_TIMEOUT, numTries)
@@ -45,27 +176,184 @@ func connectAndLogin(a *gami.Asterisk) {
 			numTries++
 			continue
 		}
+		if isReplaced(a) {
+			a.Logoff()
+			return
+		}
+		a.SendAction(gami.Message{"Action": "Events", "EventMask": "cdr,call,agent,system"}, nil)
+		metrics.AmiConnected.Set(1)
+		atomic.StoreInt32(&connected, 1)
 		if messageAlreadySent {
-			conf.Alert("Connection with asterisk restored")
-			messageAlreadySent = false
+			alert.Resolve("ami_connection", "Connection with asterisk restored")
+		}
+		if reconnect || messageAlreadySent {
+			// Events were lost while connection was down, so state
+			// built from them should be renewed
+			for _, h := range reconnectHandlers {
+				go h()
+			}
 		}
-		a.SendAction(gami.Message{"Action": "Events", "EventMask": "cdr,call"}, nil)
 		return
 	}
 }
 
 func startAmi(host, login, password string) (a *gami.Asterisk) {
 	a = gami.NewAsterisk(host, login, password)
+	watchConnection(a)
+	connectAndLogin(a, false)
+	return
+}
+
+// watchConnection restores connection with asterisk when it is lost
+func watchConnection(a *gami.Asterisk) {
 	netErrHandler := func(err error) {
-		connectAndLogin(a)
+		// Connection which was replaced by Relogin should not be restored
+		if isReplaced(a) {
+			return
+		}
+		metrics.AmiConnected.Set(0)
+		metrics.AmiReconnects.Inc()
+		atomic.StoreInt32(&connected, 0)
+		atomic.AddInt32(&reconnects, 1)
+		connectAndLogin(a, true)
 	}
 	a.SetNetErrHandler(&netErrHandler)
-	connectAndLogin(a)
-	return
+}
+
+// isReplaced tells if connection is not the current one anymore
+func isReplaced(a *gami.Asterisk) bool {
+	amiLock.RLock()
+	defer amiLock.RUnlock()
+	return ami != nil && ami != a
+}
+
+// Connected tells if dialer is connected to asterisk and how many times
+// connection was lost since start
+func Connected() (bool, int) {
+	return atomic.LoadInt32(&connected) == 1, int(atomic.LoadInt32(&reconnects))
+}
+
+// RegisterHandler subscribes handler to asterisk event. gami keeps only one handler
+// per event, so all subscribers of the same event are called from single dispatcher
+func RegisterHandler(event string, handler func(gami.Message)) {
+	eventHandlers.Lock()
+	defer eventHandlers.Unlock()
+	if _, ok := eventHandlers.m[event]; !ok {
+		dispatch := func(m gami.Message) {
+			eventHandlers.RLock()
+			handlers := eventHandlers.m[event]
+			eventHandlers.RUnlock()
+			for _, h := range handlers {
+				h(m)
+			}
+		}
+		GetAMI().RegisterHandler(event, &dispatch)
+		eventHandlers.dispatchers[event] = &dispatch
+	}
+	eventHandlers.m[event] = append(eventHandlers.m[event], handler)
+}
+
+// OnReconnect registers function to be called after connection with asterisk
+// was lost and restored
+func OnReconnect(h func()) {
+	reconnectHandlers = append(reconnectHandlers, h)
+}
+
+func listenEventList(completeEvent string, itemEvents []string) {
+	eventLists.Lock()
+	defer eventLists.Unlock()
+	for _, event := range itemEvents {
+		if _, ok := eventLists.registered[event]; !ok {
+			eventLists.registered[event] = struct{}{}
+			RegisterHandler(event, collectListEvent)
+		}
+	}
+	if _, ok := eventLists.registered[completeEvent]; !ok {
+		eventLists.registered[completeEvent] = struct{}{}
+		RegisterHandler(completeEvent, completeEventList)
+	}
+}
+
+func getEventList(actionId string) *eventList {
+	l, ok := eventLists.m[actionId]
+	if !ok {
+		l = &eventList{created: time.Now()}
+		eventLists.m[actionId] = l
+	}
+	return l
+}
+
+func collectListEvent(m gami.Message) {
+	if m["ActionID"] == "" {
+		return
+	}
+	eventLists.Lock()
+	defer eventLists.Unlock()
+	l := getEventList(m["ActionID"])
+	l.items = append(l.items, m)
+}
+
+func completeEventList(m gami.Message) {
+	if m["ActionID"] == "" {
+		return
+	}
+	eventLists.Lock()
+	defer eventLists.Unlock()
+	l := getEventList(m["ActionID"])
+	l.complete = true
+	// Handlers are not called in order, so complete event may come earlier than
+	// the last items of the list
+	l.expected, _ = strconv.Atoi(m["ListItems"])
+
+	// Lists nobody waits for anymore
+	for actionId, list := range eventLists.m {
+		if time.Since(list.created) > 2*conf.EVENT_LIST_TIMEOUT {
+			delete(eventLists.m, actionId)
+		}
+	}
+}
+
+func takeEventList(actionId string) ([]gami.Message, bool) {
+	eventLists.Lock()
+	defer eventLists.Unlock()
+	l, ok := eventLists.m[actionId]
+	if !ok || !l.complete || len(l.items) < l.expected {
+		return nil, false
+	}
+	delete(eventLists.m, actionId)
+	return l.items, true
+}
+
+// sendEventList sends action, which asterisk answers with list of events, and
+// collects all events related to it until complete event is received. All types
+// of events in the list should be provided, as they are counted by asterisk
+func sendEventList(m gami.Message, completeEvent string, itemEvents ...string) (
+	[]gami.Message, error) {
+	listenEventList(completeEvent, itemEvents)
+	resp, err := sender(m)
+	if err != nil {
+		return nil, err
+	}
+	if resp["Response"] == "Error" {
+		return nil, errors.New(resp["Message"])
+	}
+	actionId := resp["ActionID"]
+	if actionId == "" {
+		return nil, errors.New("No ActionID in response")
+	}
+
+	deadEnd := time.Now().Add(conf.EVENT_LIST_TIMEOUT)
+	for time.Now().Before(deadEnd) {
+		if items, ok := takeEventList(actionId); ok {
+			return items, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("Timeout while waiting for %s", completeEvent)
 }
 
 func getInterface(innerNumber string) string {
-	return fmt.Sprintf("SIP/%s", innerNumber)
+	return conf.GetConf().GetInterface(innerNumber)
 }
 
 func sender(param interface{}) (gami.Message, error) {
@@ -74,20 +362,32 @@ func sender(param interface{}) (gami.Message, error) {
 	cb := func(m gami.Message) {
 		cbc <- m
 	}
-	glog.Infoln("Sending to asterisk\n", param)
+	var action string
 	switch param.(type) {
 	case gami.Message:
-		err = ami.SendAction(param.(gami.Message), &cb)
+		action = param.(gami.Message)["Action"]
 	case *gami.Originate:
-		err = ami.Originate(param.(*gami.Originate), nil, &cb)
+		action = "Originate"
 	case string:
-		err = ami.Command(param.(string), &cb)
+		action = "Command"
+	}
+	log := amiLog.With(logger.Fields{"action": action})
+	log.Info("Sending to asterisk", logger.Fields{"request": param})
+	start := time.Now()
+	switch param.(type) {
+	case gami.Message:
+		err = GetAMI().SendAction(param.(gami.Message), &cb)
+	case *gami.Originate:
+		err = GetAMI().Originate(param.(*gami.Originate), nil, &cb)
+	case string:
+		err = GetAMI().Command(param.(string), &cb)
 	}
 	if err != nil {
 		return nil, err
 	}
 	resp, err := <-cbc, nil
-	glog.Infoln("Asterisk response", resp)
+	metrics.AmiActionDuration.WithLabelValues(action).Observe(time.Since(start).Seconds())
+	log.Info("Asterisk response", logger.Fields{"response": resp})
 	return resp, err
 }
 
@@ -97,14 +397,33 @@ func getContext(innerNumber string) (string, error) {
 		return staticContext, nil
 	}
 
-	resp, err := sender(gami.Message{"Action": "SIPShowPeer", "Peer": innerNumber})
-	if err != nil {
-		return "", err
-	}
-	if context, ok := resp["Context"]; ok {
-		return context, nil
-	} else {
+	switch technology := conf.GetConf().GetTechnology(innerNumber); technology {
+	case conf.SIP:
+		resp, err := sender(gami.Message{"Action": "SIPShowPeer", "Peer": innerNumber})
+		if err != nil {
+			return "", err
+		}
+		if context, ok := resp["Context"]; ok {
+			return context, nil
+		}
 		return "", errors.New("Error during SIPShowPeer")
+	case conf.PJSIP:
+		resp, err := sendEventList(
+			gami.Message{"Action": "PJSIPShowEndpoint", "Endpoint": innerNumber},
+			"EndpointDetailComplete", "EndpointDetail", "AorDetail", "AuthDetail",
+			"TransportDetail", "IdentifyDetail", "ContactStatusDetail")
+		if err != nil {
+			return "", err
+		}
+		for _, event := range resp {
+			if event["Event"] == "EndpointDetail" && event["Context"] != "" {
+				return event["Context"], nil
+			}
+		}
+		return "", errors.New("Error during PJSIPShowEndpoint")
+	default:
+		return "", fmt.Errorf("Cannot get context of %s peer, OutgoingContext should be set",
+			technology)
 	}
 }
 
@@ -125,6 +444,71 @@ func SendMixMonitor(channel, fileName string) (gami.Message, error) {
 	return sender(m)
 }
 
+func StopMixMonitor(channel string) (gami.Message, error) {
+	return sender(gami.Message{"Action": "StopMixMonitor", "Channel": channel})
+}
+
+func Hangup(channel string) (gami.Message, error) {
+	return sender(gami.Message{"Action": "Hangup", "Channel": channel})
+}
+
+// Redirect sends channel to exten in outgoing context of inner number
+func Redirect(channel, innerNumber, exten string) (gami.Message, error) {
+	context, err := getContext(innerNumber)
+	if err != nil {
+		return nil, err
+	}
+	m := gami.Message{
+		"Action":   "Redirect",
+		"Channel":  channel,
+		"Context":  context,
+		"Exten":    strings.TrimPrefix(exten, "+"),
+		"Priority": "1",
+	}
+	return sender(m)
+}
+
+// Atxfer starts attended transfer on behalf of inner number which owns the channel
+func Atxfer(channel, innerNumber, exten string) (gami.Message, error) {
+	context, err := getContext(innerNumber)
+	if err != nil {
+		return nil, err
+	}
+	m := gami.Message{
+		"Action":  "Atxfer",
+		"Channel": channel,
+		"Context": context,
+		"Exten":   strings.TrimPrefix(exten, "+"),
+	}
+	return sender(m)
+}
+
+// MuteAudio mutes or unmutes audio of channel in both directions
+func MuteAudio(channel string, mute bool) (gami.Message, error) {
+	state := "off"
+	if mute {
+		state = "on"
+	}
+	m := gami.Message{
+		"Action":    "MuteAudio",
+		"Channel":   channel,
+		"Direction": "all",
+		"State":     state,
+	}
+	return sender(m)
+}
+
+// Park parks channel, timeout channel gets parking announcement and is called back
+// when parking timeout is over
+func Park(channel, timeoutChannel string) (gami.Message, error) {
+	m := gami.Message{
+		"Action":         "Park",
+		"Channel":        channel,
+		"TimeoutChannel": timeoutChannel,
+	}
+	return sender(m)
+}
+
 func AddToQueue(queue, innerNumber string) (gami.Message, error) {
 	m := gami.Message{
 		"Action":    "QueueAdd",
@@ -135,34 +519,137 @@ func AddToQueue(queue, innerNumber string) (gami.Message, error) {
 }
 
 func RemoveFromQueue(queue, innerNumber string) (gami.Message, error) {
+	return RemoveMember(queue, getInterface(innerNumber))
+}
+
+// RemoveMember removes member from queue by interface with which it was added,
+// like Local/101ua@agents for members which are not called directly
+func RemoveMember(queue, location string) (gami.Message, error) {
 	m := gami.Message{
 		"Action":    "QueueRemove",
 		"Queue":     queue,
-		"Interface": getInterface(innerNumber),
+		"Interface": location,
 	}
 	return sender(m)
 }
 
-func QueueStatus(queue, innerNumber string) (gami.Message, error) {
-	// Before getting new state in queue need to remove old one for case
-	// then number was in queue but was removed
-	QueueState.Remove(innerNumber)
+// QueueMembers returns members of queue, or members of all queues if queue is
+// not provided
+func QueueMembers(queue string) ([]model.QueueMember, error) {
+	m := gami.Message{"Action": "QueueStatus"}
+	if queue != "" {
+		m["Queue"] = queue
+	}
+	resp, err := sendEventList(m, "QueueStatusComplete", "QueueParams", "QueueMember",
+		"QueueEntry")
+	if err != nil {
+		return nil, err
+	}
+
+	members := []model.QueueMember{}
+	for _, event := range resp {
+		if event["Event"] != "QueueMember" {
+			continue
+		}
+		iface := event["StateInterface"]
+		if iface == "" {
+			iface = event["Location"]
+		}
+		member := model.QueueMember{
+			Queue:        event["Queue"],
+			Name:         event["Name"],
+			Interface:    iface,
+			Location:     event["Location"],
+			Membership:   event["Membership"],
+			Status:       event["Status"],
+			Paused:       event["Paused"] == "1",
+			PausedReason: event["PausedReason"],
+		}
+		member.Penalty, _ = strconv.Atoi(event["Penalty"])
+		member.CallsTaken, _ = strconv.Atoi(event["CallsTaken"])
+		if lastCall, _ := strconv.ParseInt(event["LastCall"], 10, 64); lastCall > 0 {
+			t := time.Unix(lastCall, 0).UTC()
+			member.LastCall = &t
+		}
+		member.InnerNumber, member.Country = util.ParseInterface(event["Name"])
+		if member.InnerNumber == "" {
+			member.InnerNumber, member.Country = util.ParseInterface(iface)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// QueueSummary returns summary of queue, or of all queues if queue is not provided
+func QueueSummary(queue string) ([]model.QueueSummary, error) {
+	m := gami.Message{"Action": "QueueSummary"}
+	if queue != "" {
+		m["Queue"] = queue
+	}
+	resp, err := sendEventList(m, "QueueSummaryComplete", "QueueSummary")
+	if err != nil {
+		return nil, err
+	}
 
+	summaries := []model.QueueSummary{}
+	for _, event := range resp {
+		summary := model.QueueSummary{Queue: event["Queue"]}
+		summary.LoggedIn, _ = strconv.Atoi(event["LoggedIn"])
+		summary.Available, _ = strconv.Atoi(event["Available"])
+		summary.Callers, _ = strconv.Atoi(event["Callers"])
+		summary.HoldTime, _ = strconv.Atoi(event["HoldTime"])
+		summary.TalkTime, _ = strconv.Atoi(event["TalkTime"])
+		summary.LongestHoldTime, _ = strconv.Atoi(event["LongestHoldTime"])
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// PauseInQueue pauses or unpauses member in queue, or in all its queues if queue
+// is not provided
+func PauseInQueue(queue, innerNumber string, paused bool, reason string) (gami.Message, error) {
 	m := gami.Message{
-		"Action": "QueueStatus",
-		"Queue":  queue,
-		"Member": getInterface(innerNumber),
+		"Action":    "QueuePause",
+		"Interface": getInterface(innerNumber),
+		"Paused":    strconv.FormatBool(paused),
 	}
-	resp, err := sender(m)
+	if queue != "" {
+		m["Queue"] = queue
+	}
+	if reason != "" {
+		m["Reason"] = reason
+	}
+	return sender(m)
+}
+
+func SetQueuePenalty(queue, innerNumber string, penalty int) (gami.Message, error) {
+	m := gami.Message{
+		"Action":    "QueuePenalty",
+		"Interface": getInterface(innerNumber),
+		"Penalty":   strconv.Itoa(penalty),
+	}
+	if queue != "" {
+		m["Queue"] = queue
+	}
+	return sender(m)
+}
+
+func QueueStatus(queue, innerNumber string) (gami.Message, error) {
+	members, err := QueueMembers(queue)
 	if err != nil {
-		return resp, err
+		return nil, err
 	}
 
-	response := gami.Message{"Response": "success"}
-	status := QueueState.Get(innerNumber, 1, "-1")
+	status := "-1"
+	for _, member := range members {
+		if member.InnerNumber == innerNumber {
+			status = member.Status
+			break
+		}
+	}
 
 	var responseStatus string
-	switch status.(string) {
+	switch status {
 	case "-1":
 		responseStatus = "not_in_queue"
 	case "0":
@@ -172,42 +659,75 @@ func QueueStatus(queue, innerNumber string) (gami.Message, error) {
 	default:
 		responseStatus = "available"
 	}
-
-	response["StatusKey"] = responseStatus
-	return response, nil
+	return gami.Message{"Response": "success", "StatusKey": responseStatus}, nil
 }
 
 func GetActiveChannels() (gami.Message, error) {
-	return sender("sip show inuse")
+	// Default technology, as numbers with overridden one are exceptions
+	switch conf.GetConf().GetTechnology("") {
+	case conf.PJSIP:
+		return sender("pjsip show channels")
+	case conf.IAX2:
+		return sender("iax2 show channels")
+	default:
+		return sender("sip show inuse")
+	}
+}
+
+func CoreShowChannels() ([]gami.Message, error) {
+	return sendEventList(gami.Message{"Action": "CoreShowChannels"},
+		"CoreShowChannelsComplete", "CoreShowChannel")
 }
 
 func Ping() (gami.Message, error) {
 	return sender(gami.Message{"Action": "Ping"})
 }
 
-func Spy(call model.Call) (gami.Message, error) {
-	o := gami.NewOriginateApp(call.GetChannel(), "ChanSpy", fmt.Sprintf("SIP/%v", call.Exten))
+// Spy calls supervisor and starts spying on target channel, which may be either
+// full channel name or just its prefix
+func Spy(supervisor, target, options, callerId string) (gami.Message, error) {
+	o := gami.NewOriginateApp(getInterface(supervisor), "ChanSpy",
+		fmt.Sprintf("%s,%s", target, options))
+	o.CallerID = callerId
 	o.Async = true
 	return sender(o)
 }
 
+// SpyTarget returns prefix of channels of inner number for ChanSpy
+func SpyTarget(innerNumber string) string {
+	return getInterface(innerNumber) + "-"
+}
+
 func Call(call model.Call) (gami.Message, error) {
 	context, err := getContext(call.Inline)
 	if err != nil {
 		return nil, err
 	}
-	o := gami.NewOriginate(call.GetChannel(), context,
+	o := gami.NewOriginate(getInterface(call.Inline), context,
 		strings.TrimPrefix(call.Exten, "+"), "1")
 	o.CallerID = call.GetCallerID()
 	o.Async = true
 	return sender(o)
 }
 
-func CallInQueue(call model.CallInQueue) (gami.Message, error) {
+// CallToQueue calls phone number and puts it into queue when it answers
+func CallToQueue(phoneNumber, queue, callerId string) (gami.Message, error) {
+	o := gami.NewOriginateApp(
+		fmt.Sprintf("Local/%s@manager", strings.TrimPrefix(phoneNumber, "+")), "Queue", queue)
+	o.Async = true
+	o.CallerID = callerId
+	return sender(o)
+}
+
+const CALLBACK_CALLER_ID = "777 <CallMeBack>"
+
+// CallInQueue calls managers in callback queue of the country and then connects
+// the one who answered with phone number
+func CallInQueue(call model.CallInQueue, callerId string) (gami.Message, error) {
 	queue := conf.GetConf().GetCallBackQueue(call.Country)
 	o := gami.NewOriginate(queue, "manager",
 		strings.TrimPrefix(call.PhoneNumber, "+"), "1")
 	o.Async = true
-	o.CallerID = "777 <CallMeBack>"
+	o.CallerID = callerId
 	return sender(o)
 }
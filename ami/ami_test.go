@@ -0,0 +1,76 @@
+package ami
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/warik/gami"
+)
+
+func TestEventListsByActionID(t *testing.T) {
+	member := func(actionId, name string) gami.Message {
+		return gami.Message{"Event": "QueueMember", "ActionID": actionId, "Name": name}
+	}
+	complete := func(actionId, items string) gami.Message {
+		return gami.Message{"Event": "QueueStatusComplete", "ActionID": actionId, "ListItems": items}
+	}
+
+	tests := []struct {
+		name     string
+		events   []gami.Message
+		actionId string
+		items    []gami.Message
+		ok       bool
+	}{
+		{
+			name: "events of other actions are not mixed in",
+			events: []gami.Message{
+				member("a1", "101"), member("a2", "201"), member("a1", "102"),
+				complete("a2", "1"), complete("a1", "2"),
+			},
+			actionId: "a1",
+			items:    []gami.Message{member("a1", "101"), member("a1", "102")},
+			ok:       true,
+		},
+		{
+			name: "complete event before the last item",
+			events: []gami.Message{
+				member("b1", "101"), complete("b1", "2"), member("b1", "102"),
+			},
+			actionId: "b1",
+			items:    []gami.Message{member("b1", "101"), member("b1", "102")},
+			ok:       true,
+		},
+		{
+			name:     "list is not taken until all items come",
+			events:   []gami.Message{member("c1", "101"), complete("c1", "2")},
+			actionId: "c1",
+			ok:       false,
+		},
+		{
+			name:     "list is not taken without complete event",
+			events:   []gami.Message{member("d1", "101")},
+			actionId: "d1",
+			ok:       false,
+		},
+		{
+			name:     "events without ActionID are ignored",
+			events:   []gami.Message{member("", "101"), complete("", "1")},
+			actionId: "",
+			ok:       false,
+		},
+	}
+	for _, tt := range tests {
+		for _, event := range tt.events {
+			if event["Event"] == "QueueStatusComplete" {
+				completeEventList(event)
+			} else {
+				collectListEvent(event)
+			}
+		}
+		items, ok := takeEventList(tt.actionId)
+		if ok != tt.ok || !reflect.DeepEqual(items, tt.items) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, items, ok, tt.items, tt.ok)
+		}
+	}
+}
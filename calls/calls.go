@@ -0,0 +1,293 @@
+package calls
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/warik/gami"
+
+	"github.com/warik/go-dialer/ami"
+	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/util"
+)
+
+const (
+	INCOMING = "incoming"
+	OUTGOING = "outgoing"
+	INNER    = "inner"
+
+	// Events are handled concurrently, so some of them may come after hangup.
+	// Channels which were hung up are remembered for this time to ignore them
+	HANGUP_TTL = time.Minute
+)
+
+var (
+	// AMI events which change state of active channels
+	AMI_EVENTS = []string{"Newchannel", "Newstate", "BridgeEnter", "BridgeLeave", "Hangup"}
+
+	registry = struct {
+		sync.RWMutex
+		channels map[string]*channel
+		// Time of hangup by unique id of channel
+		hungUp map[string]time.Time
+	}{channels: map[string]*channel{}, hungUp: map[string]time.Time{}}
+)
+
+type channel struct {
+	Name             string
+	UniqueId         string
+	LinkedId         string
+	InnerNumber      string
+	CallerIdNumber   string
+	ConnectedLineNum string
+	State            string
+	BridgeId         string
+	StartTime        time.Time
+}
+
+func newChannel(m gami.Message, startTime time.Time) *channel {
+	ch := &channel{
+		Name:      m["Channel"],
+		UniqueId:  m["Uniqueid"],
+		LinkedId:  m["Linkedid"],
+		StartTime: startTime,
+	}
+	if ch.LinkedId == "" {
+		ch.LinkedId = ch.UniqueId
+	}
+	if innerNumberArr := util.PHONE_RE.FindStringSubmatch(ch.Name); innerNumberArr != nil {
+		ch.InnerNumber = innerNumberArr[1]
+	}
+	ch.update(m)
+	return ch
+}
+
+func (ch *channel) update(m gami.Message) {
+	if val := m["ChannelStateDesc"]; val != "" {
+		ch.State = val
+	}
+	if val := m["CallerIDNum"]; val != "" && val != "<unknown>" {
+		ch.CallerIdNumber = val
+	}
+	if val := m["ConnectedLineNum"]; val != "" && val != "<unknown>" {
+		ch.ConnectedLineNum = val
+	}
+}
+
+func (ch *channel) participant() model.CallParticipant {
+	return model.CallParticipant{
+		Channel:        ch.Name,
+		UniqueId:       ch.UniqueId,
+		InnerNumber:    ch.InnerNumber,
+		CallerIdNumber: ch.CallerIdNumber,
+		State:          ch.State,
+		Bridged:        ch.BridgeId != "",
+	}
+}
+
+// AMIEventHandler keeps registry of active channels up to date
+func AMIEventHandler(m gami.Message) {
+	uniqueId := m["Uniqueid"]
+	if uniqueId == "" {
+		return
+	}
+	registry.Lock()
+	defer registry.Unlock()
+
+	if m["Event"] == "Hangup" {
+		now := time.Now()
+		delete(registry.channels, uniqueId)
+		registry.hungUp[uniqueId] = now
+		for id, hungUpAt := range registry.hungUp {
+			if now.Sub(hungUpAt) > HANGUP_TTL {
+				delete(registry.hungUp, id)
+			}
+		}
+		return
+	}
+	if _, ok := registry.hungUp[uniqueId]; ok {
+		return
+	}
+	ch, ok := registry.channels[uniqueId]
+	if !ok {
+		// Leaving may come after hangup which was already forgotten
+		if m["Event"] == "BridgeLeave" {
+			return
+		}
+		ch = newChannel(m, time.Now())
+		registry.channels[uniqueId] = ch
+	} else {
+		ch.update(m)
+	}
+	switch m["Event"] {
+	case "BridgeEnter":
+		ch.BridgeId = m["BridgeUniqueid"]
+	case "BridgeLeave":
+		ch.BridgeId = ""
+	}
+}
+
+// Seed replaces registry with channels which are active in asterisk right now.
+// Channels created while asterisk was listing its channels are kept
+func Seed() error {
+	seedStart := time.Now()
+	resp, err := ami.CoreShowChannels()
+	if err != nil {
+		return err
+	}
+
+	channels := map[string]*channel{}
+	for _, m := range resp {
+		duration, _ := parseDuration(m["Duration"])
+		ch := newChannel(m, seedStart.Add(-duration))
+		ch.BridgeId = m["BridgeId"]
+		channels[ch.UniqueId] = ch
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	for uniqueId, ch := range registry.channels {
+		if _, ok := channels[uniqueId]; !ok && ch.StartTime.After(seedStart) {
+			channels[uniqueId] = ch
+		}
+	}
+	registry.channels = channels
+	glog.Infoln("<<< ACTIVE CHANNELS SEEDED", len(channels))
+	return nil
+}
+
+// Active returns active calls, optionally only for provided country
+func Active(country string) []model.ActiveCall {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	grouped := map[string][]*channel{}
+	for _, ch := range registry.channels {
+		grouped[ch.LinkedId] = append(grouped[ch.LinkedId], ch)
+	}
+
+	now := time.Now()
+	activeCalls := []model.ActiveCall{}
+	for linkedId, channels := range grouped {
+		sort.Slice(channels, func(i, j int) bool {
+			return channels[i].StartTime.Before(channels[j].StartTime)
+		})
+		call := model.ActiveCall{
+			Id:        linkedId,
+			StartTime: channels[0].StartTime.UTC(),
+			Duration:  int(now.Sub(channels[0].StartTime).Seconds()),
+		}
+		var innerNumber, opponentNumber string
+		for _, ch := range channels {
+			call.Participants = append(call.Participants, ch.participant())
+			// Connected line of inner number channel is its opponent for both
+			// directions, caller id of external channel is used as fallback
+			if ch.InnerNumber != "" && innerNumber == "" {
+				innerNumber, opponentNumber = ch.InnerNumber, ch.ConnectedLineNum
+			} else if ch.InnerNumber == "" && opponentNumber == "" {
+				opponentNumber = ch.CallerIdNumber
+			}
+		}
+		call.Direction = getDirection(channels)
+		if innerNumber != "" {
+			call.Country = util.GetCountryByPhones(innerNumber, opponentNumber)
+		}
+		if country != "" && call.Country != country {
+			continue
+		}
+		activeCalls = append(activeCalls, call)
+	}
+	sort.Slice(activeCalls, func(i, j int) bool {
+		return activeCalls[i].StartTime.Before(activeCalls[j].StartTime)
+	})
+	return activeCalls
+}
+
+// Find returns active channel by its unique id or by id of the call it belongs to,
+// in both cases channel of inner number is preferred. If unique id is not provided,
+// the latest channel of inner number is returned
+func Find(uniqueId, innerNumber string) (model.CallParticipant, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var found *channel
+	for _, ch := range registry.channels {
+		if uniqueId != "" {
+			if ch.UniqueId != uniqueId && ch.LinkedId != uniqueId {
+				continue
+			}
+			// Id of incoming call is unique id of customer channel, so inner number
+			// channel goes first and then the exact match
+			if found == nil || findRank(ch, uniqueId) > findRank(found, uniqueId) {
+				found = ch
+			}
+		} else if ch.InnerNumber == innerNumber && innerNumber != "" {
+			if found == nil || ch.StartTime.After(found.StartTime) {
+				found = ch
+			}
+		}
+	}
+	if found == nil {
+		return model.CallParticipant{}, errors.New("Active channel is not found")
+	}
+	return found.participant(), nil
+}
+
+func findRank(ch *channel, uniqueId string) (rank int) {
+	if ch.InnerNumber != "" {
+		rank += 2
+	}
+	if ch.UniqueId == uniqueId {
+		rank++
+	}
+	return
+}
+
+// Peer returns channel which is bridged with channel of provided unique id
+func Peer(uniqueId string) (model.CallParticipant, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	ch, ok := registry.channels[uniqueId]
+	if !ok || ch.BridgeId == "" {
+		return model.CallParticipant{}, errors.New("Channel is not bridged")
+	}
+	for _, peer := range registry.channels {
+		if peer.BridgeId == ch.BridgeId && peer.UniqueId != ch.UniqueId {
+			return peer.participant(), nil
+		}
+	}
+	return model.CallParticipant{}, errors.New("Bridged channel is not found")
+}
+
+// Direction is defined by channel which started the call: if it belongs to
+// inner number - call is outgoing, otherwise - incoming
+func getDirection(channels []*channel) string {
+	external := false
+	for _, ch := range channels {
+		if ch.InnerNumber == "" {
+			external = true
+		}
+	}
+	switch {
+	case !external:
+		return INNER
+	case channels[0].InnerNumber != "":
+		return OUTGOING
+	default:
+		return INCOMING
+	}
+}
+
+// parseDuration parses asterisk duration in format HH:MM:SS
+func parseDuration(d string) (time.Duration, error) {
+	parts := strings.Split(d, ":")
+	if len(parts) != 3 {
+		return time.ParseDuration(d + "s")
+	}
+	return time.ParseDuration(parts[0] + "h" + parts[1] + "m" + parts[2] + "s")
+}
@@ -0,0 +1,45 @@
+package calls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFind(t *testing.T) {
+	start := time.Now()
+	registry.Lock()
+	registry.channels = map[string]*channel{
+		// Incoming call, id of the call is unique id of customer channel
+		"c1": {Name: "SIP/trunk-01", UniqueId: "c1", LinkedId: "c1", StartTime: start},
+		"m1": {Name: "SIP/101-02", UniqueId: "m1", LinkedId: "c1", InnerNumber: "101",
+			StartTime: start.Add(time.Second)},
+		// Call without inner number
+		"c2": {Name: "SIP/trunk-03", UniqueId: "c2", LinkedId: "c2", StartTime: start},
+		"c3": {Name: "SIP/trunk-04", UniqueId: "c3", LinkedId: "c2", StartTime: start},
+	}
+	registry.Unlock()
+
+	tests := []struct {
+		name        string
+		uniqueId    string
+		innerNumber string
+		channel     string
+	}{
+		{"call id prefers inner number channel", "c1", "", "SIP/101-02"},
+		{"unique id of inner number channel", "m1", "", "SIP/101-02"},
+		{"call id without inner number is exact match", "c2", "", "SIP/trunk-03"},
+		{"unique id without inner number", "c3", "", "SIP/trunk-04"},
+		{"inner number", "", "101", "SIP/101-02"},
+	}
+	for _, tt := range tests {
+		ch, err := Find(tt.uniqueId, tt.innerNumber)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if ch.Channel != tt.channel {
+			t.Errorf("%s: got %s, want %s", tt.name, ch.Channel, tt.channel)
+		}
+	}
+	if _, err := Find("unknown", ""); err == nil {
+		t.Error("unknown id: channel is found")
+	}
+}
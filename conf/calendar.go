@@ -0,0 +1,159 @@
+package conf
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/warik/go-dialer/model"
+)
+
+var WEEKDAYS = map[time.Weekday]string{
+	time.Monday:    "mon",
+	time.Tuesday:   "tue",
+	time.Wednesday: "wed",
+	time.Thursday:  "thu",
+	time.Friday:    "fri",
+	time.Saturday:  "sat",
+	time.Sunday:    "sun",
+}
+
+// IsOpen tells if the country works at t by its calendar. Holidays are closed,
+// other days use weekly hours of the day or, if the day is not listed, business
+// hours. Country without any hours is always open
+func (c Configuration) IsOpen(country string, t time.Time) bool {
+	settings := c.Agencies[country]
+	local := t.In(c.GetLocation(country))
+	if isHoliday(settings, local) {
+		return false
+	}
+
+	hours, ok := settings.WeeklyHours[WEEKDAYS[local.Weekday()]]
+	if !ok {
+		hours = settings.BusinessHours
+	}
+	switch hours {
+	case "":
+		return true
+	case CLOSED:
+		return false
+	}
+	from, to, err := splitHours(hours)
+	if err != nil {
+		glog.Errorln("Bad business hours", country, err)
+		return true
+	}
+	open, err := IsInHours(local, from, to)
+	if err != nil {
+		glog.Errorln("Bad business hours", country, err)
+		return true
+	}
+	return open
+}
+
+// locations are time zones of configuration, they are loaded once when
+// configuration is read, so time.LoadLocation does not read zoneinfo on every call
+type locations struct {
+	agencies map[string]*time.Location
+	asterisk *time.Location
+}
+
+func (c *Configuration) loadLocations() {
+	l := &locations{
+		agencies: make(map[string]*time.Location, len(c.Agencies)),
+		asterisk: c.loadAsteriskLocation(),
+	}
+	for country := range c.Agencies {
+		l.agencies[country] = c.loadLocation(country)
+	}
+	c.locations = l
+}
+
+// GetLocation returns time zone of the country, UTC if it is not set
+func (c Configuration) GetLocation(country string) *time.Location {
+	if c.locations != nil {
+		if location, ok := c.locations.agencies[country]; ok {
+			return location
+		}
+	}
+	return c.loadLocation(country)
+}
+
+// GetAsteriskLocation returns time zone in which asterisk reports times
+func (c Configuration) GetAsteriskLocation() *time.Location {
+	if c.locations != nil {
+		return c.locations.asterisk
+	}
+	return c.loadAsteriskLocation()
+}
+
+func (c Configuration) loadLocation(country string) *time.Location {
+	location, err := time.LoadLocation(c.Agencies[country].TimeZone)
+	if err != nil {
+		glog.Errorln(err)
+		return time.UTC
+	}
+	return location
+}
+
+func (c Configuration) loadAsteriskLocation() *time.Location {
+	if c.AsteriskTimeZone == "" {
+		return time.FixedZone(fmt.Sprintf("UTC%+d", c.TimeZone), c.TimeZone*60*60)
+	}
+	location, err := time.LoadLocation(c.AsteriskTimeZone)
+	if err != nil {
+		glog.Errorln(err)
+		return time.UTC
+	}
+	return location
+}
+
+// Holidays are set either as dates, like 2026-05-09, or as days which are
+// holidays every year, like 01-01
+func isHoliday(settings model.CountrySettings, t time.Time) bool {
+	date, day := t.Format(DATE_FORMAT), t.Format(HOLIDAY_FORMAT)
+	for _, holiday := range settings.Holidays {
+		if holiday == date || holiday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHours splits hours like 09:00-18:00
+func splitHours(hours string) (string, string, error) {
+	fromTo := strings.Split(hours, "-")
+	if len(fromTo) != 2 {
+		return "", "", fmt.Errorf("Hours should be in format 09:00-18:00 - %s", hours)
+	}
+	return fromTo[0], fromTo[1], nil
+}
+
+// IsInHours tells if time of day of t is within hours in format 15:04,
+// hours "to" are not included and may be set to 24:00 for the end of day
+func IsInHours(t time.Time, from, to string) (bool, error) {
+	fromMinutes, err := parseHours(from)
+	if err != nil {
+		return false, err
+	}
+	toMinutes, err := parseHours(to)
+	if err != nil {
+		return false, err
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	return minutes >= fromMinutes && minutes < toMinutes, nil
+}
+
+// parseHours returns number of minutes since midnight
+func parseHours(hours string) (int, error) {
+	if hours == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse(HOURS_FORMAT, hours)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
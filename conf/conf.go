@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/parnurzeal/gorequest"
 
 	"github.com/warik/go-dialer/model"
 )
@@ -23,6 +24,39 @@ const (
 	NUMBERS_LOAD_INTERVAL     = 5 * time.Minute
 	PHONE_CALLS_SAVE_INTERVAL = 10 * time.Second
 	AMI_RECONNECT_TIMEOUT     = 5
+	AMI_RELOGIN_TIMEOUT       = 30 * time.Second
+	EVENTS_KEEPALIVE_INTERVAL = 30 * time.Second
+	STATS_REFRESH_INTERVAL    = 10 * time.Second
+	AUDIT_CLEAN_INTERVAL      = time.Hour
+	SMTP_TIMEOUT              = 30 * time.Second
+	AUDIT_RETENTION_DAYS      = 90
+	EVENT_LIST_TIMEOUT        = 5 * time.Second
+	CAMPAIGN_DIAL_INTERVAL    = 10 * time.Second
+	CAMPAIGN_DIAL_TIMEOUT     = 300
+	CAMPAIGN_RETRY_INTERVAL   = 1800
+	CAMPAIGN_HOURS_FROM       = "09:00"
+	CAMPAIGN_HOURS_TO         = "21:00"
+	CALLBACK_DIAL_INTERVAL    = 10 * time.Second
+	CALLBACK_DIAL_TIMEOUT     = 300
+	CALLBACK_RETRY_INTERVAL   = 300
+	CALLBACK_ATTEMPTS         = 3
+	CALLBACK_HANGUP_WAIT      = 5 * time.Second
+	SIGNATURE_CLOCK_SKEW      = 300
+
+	// Channel technologies
+	SIP   = "SIP"
+	PJSIP = "PJSIP"
+	IAX2  = "IAX2"
+
+	HOURS_FORMAT   = "15:04"
+	DATE_FORMAT    = "2006-01-02"
+	HOLIDAY_FORMAT = "01-02"
+	CLOSED         = "closed"
+
+	// Modes of replay protection of signed requests. In strict mode every request
+	// should have stamp, in compat mode requests without stamp are accepted
+	REPLAY_STRICT = "strict"
+	REPLAY_COMPAT = "compat"
 
 	REMOTE_ERROR_TEXT        = "Error on remote server, status code - %v"
 	CDR_DB_FILE              = "cdr_log.db"
@@ -30,14 +64,25 @@ const (
 	MAX_PHONE_CALLS_NUMBER   = 10
 	CDR_SAVERS_COUNT         = 2
 	PHONE_CALL_SENDERS_COUNT = 2
+	EVENTS_BUFFER_SIZE       = 100
+	MAX_CALLBACKS_NUMBER     = 10
+	MAX_AUDIT_RECORDS        = 100
+	AUDIT_RESULT_SIZE        = 4096
+	NONCE_CACHE_SIZE         = 100000
+	ALERTS_QUEUE_SIZE        = 100
+	ALERTS_PER_MINUTE        = 10
+	ALERT_DEDUP_INTERVAL     = 3600
+	SMS_GATEWAY_URL          = "http://sms.skysms.net/api/submit_sm"
 )
 
 var (
-	conf      Configuration
+	current   atomic.Value
 	once      sync.Once
 	config    = flag.String("config", "conf.json", "Config file name")
-	smsAlerts = flag.Bool("sms_alerts", true, "Should send sms in emergency cases")
+	SmsAlerts = flag.Bool("sms_alerts", true, "Should send sms in emergency cases")
 
+	// Built-in portals of dialer targets, they are used for agencies
+	// which have no portal in configuration
 	PORTAL_MAP = map[string]PortalMap{
 		"local": PortalMap{
 			"ua": "",
@@ -55,7 +100,15 @@ var (
 			"kz": "https://my.satu.kz/",
 		},
 	}
+	// Phones for alerts if they are not set in AlertPhones of configuration
 	ADMIN_PHONES = []string{}
+
+	changeHandlers struct {
+		sync.Mutex
+		h []func(old, new *Configuration)
+	}
+	// Reloads are done one by one, so change handlers never run concurrently
+	reloadLock sync.Mutex
 )
 
 type PortalMap map[string]string
@@ -73,10 +126,158 @@ type Configuration struct {
 	CallBackQueueSufix     string
 	QuestionaryUrl         string
 	OutgoingContext        string
+	// IANA name of time zone of asterisk server, like Europe/Kiev. Fixed offset
+	// in hours in TimeZone is used only if it is not set
+	AsteriskTimeZone string
+	// Phones to which sms alerts are sent
+	AlertPhones []string
+	// Channels of alerts, if there are none alerts are sent by sms to AlertPhones.
+	// The same alert is repeated only after AlertDedupInterval seconds and no more
+	// than AlertsPerMinute alerts are sent
+	Alerts             []model.AlertChannel
+	AlertDedupInterval int
+	AlertsPerMinute    int
+	// Networks, like 10.0.0.0/8 or single addresses, from which routes of each group
+	// (self, crm, asterisk) may be requested. AllowedRemoteAddrs are used for groups
+	// which are not listed. Client address is taken from X-Forwarded-For header
+	// only if request came from one of TrustedProxies
+	AllowedNetworks map[string][]string
+	TrustedProxies  []string
+	// Authentication of route groups (self, crm, asterisk). Groups which are not
+	// listed keep old behaviour, input params of AmiHandler routes are signed
+	// if -signed_input is set
+	Auth map[string]model.RouteAuth
+	// Files of certificate and key of https server and CA of client certificates,
+	// http is served if TLSCert is not set
+	TLSCert, TLSKey, ClientCA string
+	// Replay protection of signed requests, compat by default. Stamp of request may
+	// differ from time of dialer by SignatureClockSkew seconds and its nonce is kept
+	// until the stamp is stale. NonceCacheSize should be more than number of signed
+	// requests in 2 * SignatureClockSkew, otherwise new ones are rejected
+	ReplayProtection   string
+	SignatureClockSkew int
+	NonceCacheSize     int
+	// Days for which audit records of api calls are kept
+	AuditRetentionDays int
+	// Levels of structured logs by component, like {"cdr": "debug"}. Level of
+	// "default" is used for components which are not listed, info if it is not set
+	LogLevels map[string]string
+	// Portal api for states of calls placed from CRM, states are not sent if empty
+	CallStatusApi string
+	// Callback requests are dialed CallBackAttempts times with CallBackRetryInterval
+	// seconds between attempts and, if CallBackInBusinessHours is set, only when the
+	// country is open by its calendar. Their states are sent to CallBackStatusApi if it is set
+	CallBackAttempts        int
+	CallBackRetryInterval   int
+	CallBackInBusinessHours bool
+	CallBackStatusApi       string
+	// Channel technology of inner numbers, SIP by default. It can be
+	// overridden for some numbers in Technologies
+	Technology   string
+	Technologies map[string]string
+
+	locations *locations
+}
+
+func (c Configuration) GetTechnology(innerNumber string) string {
+	if technology, ok := c.Technologies[innerNumber]; ok {
+		return technology
+	}
+	if c.Technology != "" {
+		return c.Technology
+	}
+	return SIP
+}
+
+// GetInterface returns dial string of inner number, which is used also
+// as its interface in queues
+func (c Configuration) GetInterface(innerNumber string) string {
+	return fmt.Sprintf("%s/%s", c.GetTechnology(innerNumber), innerNumber)
+}
+
+func (c Configuration) GetCountryByCompanyId(companyId string) string {
+	for country, settings := range c.Agencies {
+		if settings.CompanyId == companyId {
+			return country
+		}
+	}
+	return ""
+}
+
+func (c Configuration) GetReplayProtection(country string) string {
+	if mode := c.Agencies[country].ReplayProtection; mode != "" {
+		return mode
+	}
+	if c.ReplayProtection != "" {
+		return c.ReplayProtection
+	}
+	return REPLAY_COMPAT
+}
+
+func (c Configuration) GetSignatureClockSkew() time.Duration {
+	if c.SignatureClockSkew <= 0 {
+		return SIGNATURE_CLOCK_SKEW * time.Second
+	}
+	return time.Duration(c.SignatureClockSkew) * time.Second
+}
+
+func (c Configuration) GetNonceCacheSize() int {
+	if c.NonceCacheSize <= 0 {
+		return NONCE_CACHE_SIZE
+	}
+	return c.NonceCacheSize
+}
+
+func (c Configuration) GetAuditRetentionDays() int {
+	if c.AuditRetentionDays <= 0 {
+		return AUDIT_RETENTION_DAYS
+	}
+	return c.AuditRetentionDays
+}
+
+func (c Configuration) GetAlertDedupInterval() time.Duration {
+	if c.AlertDedupInterval <= 0 {
+		return ALERT_DEDUP_INTERVAL * time.Second
+	}
+	return time.Duration(c.AlertDedupInterval) * time.Second
+}
+
+func (c Configuration) GetAlertsPerMinute() int {
+	if c.AlertsPerMinute <= 0 {
+		return ALERTS_PER_MINUTE
+	}
+	return c.AlertsPerMinute
+}
+
+// GetPortal returns portal of the country, with defaults of dialer target
+// for the values which are not set
+func (c Configuration) GetPortal(country string) model.Portal {
+	portal := c.Agencies[country].Portal
+	if portal.Url == "" {
+		portal.Url = PORTAL_MAP[c.Target][country]
+	}
+	if portal.Api == "" {
+		portal.Api = c.Api
+	}
+	return portal
 }
 
 func (c Configuration) GetApi(country string, apiKey string) string {
-	return PORTAL_MAP[c.Target][country] + c.Api + apiKey
+	portal := c.GetPortal(country)
+	if api, ok := portal.Apis[apiKey]; ok {
+		if strings.HasPrefix(api, "http://") || strings.HasPrefix(api, "https://") {
+			return api
+		}
+		apiKey = api
+	}
+	return portal.Url + portal.Api + apiKey
+}
+
+func (c Configuration) GetAlertPhones() []string {
+	if len(c.AlertPhones) == 0 {
+		return ADMIN_PHONES
+	}
+	return c.AlertPhones
 }
 
 func (c Configuration) GetCallBackQueueSufix() string {
@@ -94,44 +295,94 @@ func (c Configuration) GetCallBackQueue(country string) string {
 	return fmt.Sprintf("%s@%s%s", prefix, country, sufix)
 }
 
+func (c Configuration) GetCallBackAttempts() int {
+	if c.CallBackAttempts <= 0 {
+		return CALLBACK_ATTEMPTS
+	}
+	return c.CallBackAttempts
+}
+
+func (c Configuration) GetCallBackRetryInterval() int {
+	if c.CallBackRetryInterval <= 0 {
+		return CALLBACK_RETRY_INTERVAL
+	}
+	return c.CallBackRetryInterval
+}
+
 func (c Configuration) GetReviewUri(uniqueId string) string {
 	return fmt.Sprintf("%s?callid=%s", c.QuestionaryUrl, uniqueId)
 }
 
 func InitConf() {
+	c, err := loadConf()
+	if err != nil {
+		panic(err)
+	}
+	current.Store(c)
+}
+
+func loadConf() (*Configuration, error) {
 	path, _ := filepath.Abs(filepath.Dir(os.Args[0]))
 	file, err := os.Open(filepath.Join(path, *config))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer file.Close()
 
-	conf = Configuration{}
-	err = json.NewDecoder(file).Decode(&conf)
-	if err != nil {
-		panic(err)
+	c := &Configuration{}
+	if err = json.NewDecoder(file).Decode(c); err != nil {
+		return nil, err
 	}
+	if err = c.applyOverrides(); err != nil {
+		return nil, err
+	}
+	c.loadLocations()
+	return c, nil
 }
 
+// GetConf returns current configuration. It may be replaced by Reload, so the
+// result should not be kept for long
 func GetConf() *Configuration {
 	once.Do(InitConf)
-	return &conf
-}
-
-func Alert(msg string) {
-	if !*smsAlerts {
-		return
-	}
-	url := "http://sms.skysms.net/api/submit_sm"
-	msg = fmt.Sprintf("%s: %s", conf.Name, msg)
-	for _, phone := range ADMIN_PHONES {
-		params := fmt.Sprintf("login=%s&passwd=%s&destaddr=%s&msgchrset=cyr&msgtext=%s",
-			"", "", phone, msg)
-		_, _, errs := gorequest.New().Get(url).Query(params).End()
-		if len(errs) != 0 {
-			for _, err := range errs {
-				glog.Errorln(err)
-			}
-		}
+	return current.Load().(*Configuration)
+}
+
+// SetConf replaces current configuration without reading configuration file,
+// it is used by tests
+func SetConf(c *Configuration) {
+	once.Do(func() {})
+	c.loadLocations()
+	current.Store(c)
+}
+
+// OnChange registers function to be called after configuration was reloaded
+func OnChange(h func(old, new *Configuration)) {
+	changeHandlers.Lock()
+	defer changeHandlers.Unlock()
+	changeHandlers.h = append(changeHandlers.h, h)
+}
+
+// Reload reads configuration file again and, if it is valid, replaces
+// current configuration with it. Old configuration is kept otherwise
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	c, err := loadConf()
+	if err != nil {
+		return err
+	}
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	old := GetConf()
+	current.Store(c)
+	glog.Infoln("<<< CONFIGURATION RELOADED")
+	changeHandlers.Lock()
+	handlers := changeHandlers.h
+	changeHandlers.Unlock()
+	for _, h := range handlers {
+		h(old, c)
 	}
+	return nil
 }
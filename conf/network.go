@@ -0,0 +1,115 @@
+package conf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// Groups of routes, each of them has its own allowed networks and authentication
+const (
+	ROUTES_SELF     = "self"
+	ROUTES_CRM      = "crm"
+	ROUTES_ASTERISK = "asterisk"
+)
+
+var ROUTE_GROUPS = []string{ROUTES_SELF, ROUTES_CRM, ROUTES_ASTERISK}
+
+// Types of authentication of route groups
+const (
+	AUTH_NONE  = "none"
+	AUTH_HMAC  = "hmac"
+	AUTH_TOKEN = "token"
+	AUTH_MTLS  = "mtls"
+)
+
+// ParseNetwork parses network in CIDR notation, like 10.0.0.0/8 or fd00::/8.
+// Single address, IPv4 or IPv6, is a network of only this address
+func ParseNetwork(network string) (*net.IPNet, error) {
+	if !strings.Contains(network, "/") {
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, fmt.Errorf("Bad address %q", network)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	return ipNet, err
+}
+
+func parseNetworks(networks []string) []*net.IPNet {
+	ipNets := []*net.IPNet{}
+	for _, network := range networks {
+		// Networks are validated on load, so bad ones are only skipped here
+		if ipNet, err := ParseNetwork(network); err == nil {
+			ipNets = append(ipNets, ipNet)
+		}
+	}
+	return ipNets
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllowedNetworks returns networks from which routes of the group may be
+// requested. AllowedRemoteAddrs are used for groups which are not in
+// AllowedNetworks, empty result means that requests are allowed from anywhere
+func (c Configuration) GetAllowedNetworks(group string) []string {
+	if networks, ok := c.AllowedNetworks[group]; ok {
+		return networks
+	}
+	return c.AllowedRemoteAddrs
+}
+
+// IsAllowedIP reports whether routes of the group may be requested from ip
+func (c Configuration) IsAllowedIP(group string, ip net.IP) bool {
+	networks := c.GetAllowedNetworks(group)
+	if len(networks) == 0 {
+		return true
+	}
+	return ip != nil && containsIP(parseNetworks(networks), ip)
+}
+
+// IsTrustedProxy reports whether X-Forwarded-For header of requests from ip can be trusted
+func (c Configuration) IsTrustedProxy(ip net.IP) bool {
+	return ip != nil && containsIP(parseNetworks(c.TrustedProxies), ip)
+}
+
+// GetTLSConfig returns configuration of https server, nil if TLSCert is not set.
+// Client certificates are verified by ClientCA, but they are required only by
+// routes with mtls authentication
+func (c Configuration) GetTLSConfig() (*tls.Config, error) {
+	if c.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.ClientCA != "" {
+		pem, err := ioutil.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates in %s", c.ClientCA)
+		}
+		config.ClientCAs, config.ClientAuth = pool, tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
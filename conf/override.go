@@ -0,0 +1,224 @@
+package conf
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/warik/go-dialer/model"
+)
+
+// Values of configuration may be overridden without changing conf.json. Every value
+// has a name, like AMI_PASSWORD or AGENCY_UA_SECRET, and is taken from the first of:
+//
+//  1. flag -set NAME=value, it may be repeated
+//  2. environment variable DIALER_NAME
+//  3. file which path is in environment variable DIALER_NAME_FILE, like mounted secret
+//  4. conf.json
+//
+// Values of agencies are overridden only for agencies which are in conf.json.
+// Secrets can not be set by flag, since command line of process is readable by
+// anyone on the host.
+const (
+	ENV_PREFIX = "DIALER_"
+	REDACTED   = "******"
+)
+
+var (
+	flagOverrides = overrideFlag{}
+
+	SECRET_OVERRIDES = []string{"AMI_PASSWORD", "SECRET", "STORAGE_ACCESS_KEY", "STORAGE_SECRET_KEY"}
+)
+
+func init() {
+	flag.Var(flagOverrides, "set", "Override configuration value, like -set ASTERISK_HOST=10.0.0.1")
+}
+
+type overrideFlag map[string]string
+
+func (f overrideFlag) String() string {
+	names := []string{}
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (f overrideFlag) Set(value string) error {
+	nameValue := strings.SplitN(value, "=", 2)
+	if len(nameValue) != 2 || nameValue[0] == "" {
+		return errors.New("Override should be in format NAME=value")
+	}
+	name := strings.ToUpper(nameValue[0])
+	if isSecretOverride(name) {
+		return fmt.Errorf("Secret %s can not be set by flag, use %s%s or %s%s_FILE",
+			name, ENV_PREFIX, name, ENV_PREFIX, name)
+	}
+	f[name] = nameValue[1]
+	return nil
+}
+
+func isSecretOverride(name string) bool {
+	if strings.HasPrefix(name, "AGENCY_") && strings.HasSuffix(name, "_SECRET") {
+		return true
+	}
+	for _, secret := range SECRET_OVERRIDES {
+		if secret == name {
+			return true
+		}
+	}
+	return false
+}
+
+// override is a configuration value which may be overridden
+type override struct {
+	name string
+	set  func(value string)
+}
+
+func (c *Configuration) overrides() []override {
+	if c.StorageSettings == nil {
+		c.StorageSettings = map[string]string{}
+	}
+	overrides := []override{
+		{"ASTERISK_HOST", func(v string) { c.AsteriskHost = v }},
+		{"AMI_LOGIN", func(v string) { c.AMILogin = v }},
+		{"AMI_PASSWORD", func(v string) { c.AMIPassword = v }},
+		{"SECRET", func(v string) { c.Secret = v }},
+		{"NAME", func(v string) { c.Name = v }},
+		{"TARGET", func(v string) { c.Target = v }},
+		{"API", func(v string) { c.Api = v }},
+		{"FOLDER_FOR_CALLS", func(v string) { c.FolderForCalls = v }},
+		{"STORAGE_ACCESS_KEY", func(v string) { c.StorageSettings["accessKey"] = v }},
+		{"STORAGE_SECRET_KEY", func(v string) { c.StorageSettings["secretKey"] = v }},
+		{"STORAGE_S3_HOST", func(v string) { c.StorageSettings["s3Host"] = v }},
+		{"STORAGE_BUCKET", func(v string) { c.StorageSettings["bucket"] = v }},
+	}
+	for country := range c.Agencies {
+		country := country
+		prefix := fmt.Sprintf("AGENCY_%s_", strings.ToUpper(country))
+		overrides = append(overrides,
+			override{prefix + "SECRET", func(v string) {
+				settings := c.Agencies[country]
+				settings.Secret = v
+				c.Agencies[country] = settings
+			}},
+			override{prefix + "COMPANY_ID", func(v string) {
+				settings := c.Agencies[country]
+				settings.CompanyId = v
+				c.Agencies[country] = settings
+			}},
+		)
+	}
+	return overrides
+}
+
+// applyOverrides replaces values of configuration by values from flags,
+// environment variables and secret files
+func (c *Configuration) applyOverrides() error {
+	for _, o := range c.overrides() {
+		value, ok, err := lookupOverride(o.name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			o.set(value)
+		}
+	}
+	return nil
+}
+
+func lookupOverride(name string) (string, bool, error) {
+	if value, ok := flagOverrides[name]; ok {
+		return value, true, nil
+	}
+	if value, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+		return value, true, nil
+	}
+	if path := os.Getenv(ENV_PREFIX + name + "_FILE"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("Cannot read %s%s_FILE - %v", ENV_PREFIX, name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return "", false, nil
+}
+
+// secrets returns values of configuration which should not be shown in logs
+func (c Configuration) secrets() []string {
+	secrets := []string{c.AMIPassword, c.Secret, c.StorageSettings["accessKey"],
+		c.StorageSettings["secretKey"]}
+	for _, settings := range c.Agencies {
+		secrets = append(secrets, settings.Secret)
+	}
+	for _, channel := range c.Alerts {
+		secrets = append(secrets, channel.Password)
+	}
+	for _, auth := range c.Auth {
+		secrets = append(secrets, auth.Tokens...)
+	}
+	return secrets
+}
+
+// Redact returns text representation of v with secrets of current
+// configuration replaced, so it can be written to logs
+func Redact(v interface{}) string {
+	text := fmt.Sprint(v)
+	for _, secret := range GetConf().secrets() {
+		if secret != "" {
+			text = strings.Replace(text, secret, REDACTED, -1)
+		}
+	}
+	return text
+}
+
+// String returns configuration as json with secrets replaced
+func (c Configuration) String() string {
+	c.AMIPassword, c.Secret = redact(c.AMIPassword), redact(c.Secret)
+	storageSettings := map[string]string{}
+	for key, value := range c.StorageSettings {
+		if key == "accessKey" || key == "secretKey" {
+			value = redact(value)
+		}
+		storageSettings[key] = value
+	}
+	c.StorageSettings = storageSettings
+	agencies := map[string]model.CountrySettings{}
+	for country, settings := range c.Agencies {
+		settings.Secret = redact(settings.Secret)
+		agencies[country] = settings
+	}
+	c.Agencies = agencies
+	alerts := []model.AlertChannel{}
+	for _, channel := range c.Alerts {
+		channel.Password = redact(channel.Password)
+		alerts = append(alerts, channel)
+	}
+	c.Alerts = alerts
+	auth := map[string]model.RouteAuth{}
+	for group, groupAuth := range c.Auth {
+		tokens := []string{}
+		for _, token := range groupAuth.Tokens {
+			tokens = append(tokens, redact(token))
+		}
+		groupAuth.Tokens = tokens
+		auth[group] = groupAuth
+	}
+	c.Auth = auth
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return REDACTED
+}
@@ -0,0 +1,259 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Parts of configuration which are required only by some features of the dialer
+type Requirements struct {
+	// Calls are recorded to FolderForCalls and converted to its _mp3 sibling
+	CallsFolder bool
+	// Converted calls are sent to storage
+	Storage bool
+}
+
+var (
+	required Requirements
+
+	STORAGE_SETTINGS = []string{"accessKey", "secretKey", "s3Host", "bucket"}
+)
+
+// ValidationError contains all problems found in configuration
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Require sets which optional parts of configuration should be validated
+func Require(r Requirements) {
+	required = r
+}
+
+// Check reads configuration file and validates it without replacing current
+// configuration, so it can be used to check configuration before start
+func Check() error {
+	c, err := loadConf()
+	if err != nil {
+		return ValidationError{fmt.Sprintf("Cannot read %s - %v", *config, err)}
+	}
+	return c.Validate()
+}
+
+// Validate checks that configuration can be used by the dialer, all found
+// problems are returned in one ValidationError
+func (c *Configuration) Validate() error {
+	errs := ValidationError{}
+	addErr := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+
+	if c.AsteriskHost == "" || c.AMILogin == "" {
+		addErr("AsteriskHost and AMILogin are required")
+	}
+	if len(c.Agencies) == 0 {
+		addErr("At least one agency is required in Agencies")
+	}
+	if c.AsteriskTimeZone != "" {
+		if _, err := time.LoadLocation(c.AsteriskTimeZone); err != nil {
+			addErr("Bad AsteriskTimeZone - %v", err)
+		}
+	}
+	for country, settings := range c.Agencies {
+		if settings.Secret == "" || settings.CompanyId == "" {
+			addErr("Agency %s should have secret and companyId", country)
+		}
+		if c.GetPortal(country).Url == "" {
+			addErr("Agency %s has no portal url and there is no built-in portal for it in target %q",
+				country, c.Target)
+		}
+		if settings.TimeZone != "" {
+			if _, err := time.LoadLocation(settings.TimeZone); err != nil {
+				addErr("Bad time zone of %s - %v", country, err)
+			}
+		}
+		hours := []string{settings.BusinessHours}
+		for day, dayHours := range settings.WeeklyHours {
+			if !isWeekday(day) {
+				addErr("Bad day of week of %s - %s", country, day)
+			}
+			hours = append(hours, dayHours)
+		}
+		for _, h := range hours {
+			if err := validateHours(h); err != nil {
+				addErr("Bad business hours of %s - %v", country, err)
+			}
+		}
+		if !isReplayProtection(settings.ReplayProtection) {
+			addErr("Unknown replayProtection of %s - %s", country, settings.ReplayProtection)
+		}
+		for _, holiday := range settings.Holidays {
+			_, dateErr := time.Parse(DATE_FORMAT, holiday)
+			_, dayErr := time.Parse(HOLIDAY_FORMAT, holiday)
+			if dateErr != nil && dayErr != nil {
+				addErr("Bad holiday of %s - %s", country, holiday)
+			}
+		}
+	}
+
+	if !isReplayProtection(c.ReplayProtection) {
+		addErr("Unknown ReplayProtection - %s", c.ReplayProtection)
+	}
+	for group := range c.AllowedNetworks {
+		if !isRouteGroup(group) {
+			addErr("Unknown route group in AllowedNetworks - %s", group)
+		}
+	}
+	networks := append(append([]string{}, c.AllowedRemoteAddrs...), c.TrustedProxies...)
+	for _, groupNetworks := range c.AllowedNetworks {
+		networks = append(networks, groupNetworks...)
+	}
+	for _, network := range networks {
+		if _, err := ParseNetwork(network); err != nil {
+			addErr("Bad allowed network - %v", err)
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		addErr("TLSCert and TLSKey should be set together")
+	} else if c.ClientCA != "" && c.TLSCert == "" {
+		addErr("ClientCA requires TLSCert and TLSKey")
+	} else if _, err := c.GetTLSConfig(); err != nil {
+		addErr("Bad TLS settings - %v", err)
+	}
+	// Without Auth routes of crm are checked by -signed_input, but other groups take
+	// plain params, so open access to them should be chosen explicitly
+	for _, group := range []string{ROUTES_SELF, ROUTES_ASTERISK} {
+		if _, ok := c.Auth[group]; !ok {
+			addErr("Auth of %s is required, its type may be %q to allow requests without authentication",
+				group, AUTH_NONE)
+		}
+	}
+	for group, auth := range c.Auth {
+		if !isRouteGroup(group) {
+			addErr("Unknown route group in Auth - %s", group)
+		}
+		switch auth.Type {
+		case AUTH_NONE, AUTH_HMAC:
+		case AUTH_TOKEN:
+			if len(auth.Tokens) == 0 {
+				addErr("Auth of %s should have tokens", group)
+			}
+		case AUTH_MTLS:
+			if c.ClientCA == "" {
+				addErr("Auth of %s requires TLSCert, TLSKey and ClientCA", group)
+			}
+		default:
+			addErr("Auth of %s has unknown type %q", group, auth.Type)
+		}
+	}
+
+	for i, channel := range c.Alerts {
+		switch channel.Type {
+		case "sms":
+		case "webhook", "slack":
+			if channel.Url == "" {
+				addErr("Alert channel %d (%s) should have url", i, channel.Type)
+			}
+		case "smtp":
+			if channel.Url == "" || channel.From == "" || len(channel.Recipients) == 0 {
+				addErr("Alert channel %d (smtp) should have url, from and recipients", i)
+			}
+		default:
+			addErr("Alert channel %d has unknown type %q", i, channel.Type)
+		}
+		switch channel.MinSeverity {
+		case "", "info", "warning", "critical":
+		default:
+			addErr("Alert channel %d has unknown minSeverity %q", i, channel.MinSeverity)
+		}
+	}
+
+	for component, level := range c.LogLevels {
+		switch level {
+		case "debug", "info", "warning", "error":
+		default:
+			addErr("Unknown log level of %s - %q", component, level)
+		}
+	}
+
+	if required.CallsFolder && c.FolderForCalls == "" {
+		addErr("FolderForCalls is required to save and send calls")
+	} else if required.CallsFolder {
+		for _, folder := range []string{c.FolderForCalls, c.FolderForCalls + "_mp3"} {
+			if err := checkWritable(folder); err != nil {
+				addErr("Folder for calls is not writable - %v", err)
+			}
+		}
+	}
+	if required.Storage {
+		for _, key := range STORAGE_SETTINGS {
+			if c.StorageSettings[key] == "" {
+				addErr("StorageSettings should have %s", key)
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkWritable checks that folder exists and files can be created in it
+func checkWritable(folder string) error {
+	info, err := os.Stat(folder)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", folder)
+	}
+	file, err := ioutil.TempFile(folder, ".check")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func validateHours(hours string) error {
+	if hours == "" || hours == CLOSED {
+		return nil
+	}
+	from, to, err := splitHours(hours)
+	if err != nil {
+		return err
+	}
+	if _, err = parseHours(from); err != nil {
+		return err
+	}
+	_, err = parseHours(to)
+	return err
+}
+
+func isReplayProtection(mode string) bool {
+	return mode == "" || mode == REPLAY_STRICT || mode == REPLAY_COMPAT
+}
+
+func isRouteGroup(group string) bool {
+	for _, g := range ROUTE_GROUPS {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeekday(day string) bool {
+	for _, weekday := range WEEKDAYS {
+		if weekday == day {
+			return true
+		}
+	}
+	return false
+}
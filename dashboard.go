@@ -0,0 +1,107 @@
+package main
+
+// DASHBOARD_PAGE shows stats of the dialer and reloads them every %d milliseconds
+const DASHBOARD_PAGE = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Dialer stats</title>
+	<style>
+		body { font-family: sans-serif; margin: 20px; color: #222; }
+		table { border-collapse: collapse; margin-bottom: 20px; }
+		th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+		th { background: #f0f0f0; }
+		.ok { color: #080; }
+		.error { color: #c00; }
+	</style>
+</head>
+<body>
+	<h1><span id="name"></span> dialer stats</h1>
+	<p>
+		<b>Uptime</b>: <span id="uptime"></span> |
+		<b>AMI</b>: <span id="ami"></span> |
+		<b>Phone calls backlog</b>: <span id="phone_call_backlog"></span> |
+		<b>Oldest pending cdr</b>: <span id="oldest_pending_age"></span>
+	</p>
+	<h2>Countries</h2>
+	<table>
+		<thead><tr>
+			<th>Country</th><th>CDR backlog</th><th>Last portal send</th>
+			<th>Inner numbers</th><th>Duplicates</th>
+		</tr></thead>
+		<tbody id="countries"></tbody>
+	</table>
+	<h2>Queues</h2>
+	<p id="queues_error" class="error"></p>
+	<table>
+		<thead><tr>
+			<th>Queue</th><th>Logged in</th><th>Available</th><th>Callers</th>
+			<th>Hold time</th><th>Talk time</th><th>Longest hold time</th>
+		</tr></thead>
+		<tbody id="queues"></tbody>
+	</table>
+	<p>Updated: <span id="updated"></span></p>
+	<script>
+		function duration(seconds) {
+			var h = Math.floor(seconds / 3600), m = Math.floor(seconds %% 3600 / 60);
+			return h + "h " + m + "m " + seconds %% 60 + "s";
+		}
+		function row(cells) {
+			var tr = document.createElement("tr");
+			cells.forEach(function(cell) {
+				var td = document.createElement("td");
+				td.textContent = cell;
+				tr.appendChild(td);
+			});
+			return tr;
+		}
+		function fill(id, rows) {
+			var tbody = document.getElementById(id);
+			tbody.innerHTML = "";
+			rows.forEach(function(cells) { tbody.appendChild(row(cells)); });
+		}
+		function text(id, value) {
+			document.getElementById(id).textContent = value;
+		}
+		function render(stats) {
+			text("name", stats.name);
+			text("uptime", duration(stats.uptime));
+			var ami = document.getElementById("ami");
+			ami.textContent = (stats.ami.connected ? "connected" : "disconnected") +
+				", reconnects: " + stats.ami.reconnects;
+			ami.className = stats.ami.connected ? "ok" : "error";
+			text("phone_call_backlog", stats.phone_call_backlog);
+			text("oldest_pending_age", duration(stats.oldest_pending_age));
+
+			var countries = {};
+			[stats.cdr_backlog, stats.last_portal_sends, stats.inner_numbers].forEach(function(m) {
+				Object.keys(m || {}).forEach(function(c) { countries[c] = true; });
+			});
+			fill("countries", Object.keys(countries).sort().map(function(c) {
+				var numbers = (stats.inner_numbers || {})[c] || {};
+				return [c, (stats.cdr_backlog || {})[c] || 0, (stats.last_portal_sends || {})[c] || "-",
+					numbers.loaded || 0, numbers.duplicates || 0];
+			}));
+			text("queues_error", stats.queues_error || "");
+			fill("queues", (stats.queues || []).map(function(q) {
+				return [q.queue, q.logged_in, q.available, q.callers, q.hold_time, q.talk_time,
+					q.longest_hold_time];
+			}));
+			text("updated", new Date().toLocaleTimeString());
+		}
+		function refresh() {
+			var xhr = new XMLHttpRequest();
+			xhr.open("GET", "/stats");
+			xhr.onload = function() {
+				if (xhr.status === 200) {
+					render(JSON.parse(xhr.responseText));
+				}
+			};
+			xhr.send();
+		}
+		refresh();
+		setInterval(refresh, %d);
+	</script>
+</body>
+</html>
+`
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+)
+
+const (
+	INSERT_AUDIT_STMT = `
+		INSERT INTO audit_log (remote_addr, country, route, params, result, status)
+		values (:remote_addr, :country, :route, :params, :result, :status)
+	`
+	// Empty filters match all records
+	SELECT_AUDIT_STMT = `
+		SELECT * FROM audit_log
+		where ($1 = '' or route = $1) and ($2 = '' or country = $2)
+			and ($3 = '' or created >= $3) and ($4 = '' or created < $4)
+		order by id desc limit $5
+	`
+	DELETE_OLD_AUDIT_STMT = "DELETE FROM audit_log where created < datetime('now', '-' || $1 || ' days')"
+)
+
+type AuditRecord struct {
+	ID         int    `db:"id" json:"id"`
+	Created    string `db:"created" json:"created"`
+	RemoteAddr string `db:"remote_addr" json:"remote_addr"`
+	// Country which secret the request was signed with, empty for not signed requests
+	Country string `db:"country" json:"country"`
+	Route   string `db:"route" json:"route"`
+	Params  string `db:"params" json:"params"`
+	Result  string `db:"result" json:"result"`
+	Status  string `db:"status" json:"status"`
+}
+
+func (db *DBWrapper) AddAuditRecord(record AuditRecord) (sql.Result, error) {
+	db.Lock()
+	defer db.Unlock()
+	return namedExec(INSERT_AUDIT_STMT, record)
+}
+
+// SelectAuditRecords returns the latest audit records, optionally filtered by route,
+// country and time in format 2006-01-02 15:04:05 UTC
+func (db *DBWrapper) SelectAuditRecords(route, country, from, to string, limit int) (
+	[]AuditRecord, error) {
+	db.Lock()
+	defer db.Unlock()
+	records := []AuditRecord{}
+	err := db.Select(&records, SELECT_AUDIT_STMT, route, country, from, to, limit)
+	return records, err
+}
+
+// DeleteOldAuditRecords deletes records older than retention in days
+func (db *DBWrapper) DeleteOldAuditRecords(retention int) (sql.Result, error) {
+	db.Lock()
+	defer db.Unlock()
+	return db.Exec(DELETE_OLD_AUDIT_STMT, retention)
+}
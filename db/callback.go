@@ -0,0 +1,158 @@
+package db
+
+import (
+	"database/sql"
+)
+
+const (
+	SELECT_ACTIVE_CALLBACK_STMT = `
+		SELECT * FROM callback
+		where ltrim(phone_number, '+') = ltrim($1, '+') and status in ('pending', 'dialing')
+	`
+	INSERT_CALLBACK_STMT = `
+		INSERT INTO callback (phone_number, country, status, next_attempt)
+		values ($1, $2, 'pending', datetime('now', '+' || $3 || ' seconds'))
+	`
+	SELECT_DUE_CALLBACKS_STMT = `
+		SELECT * FROM callback where status = 'pending' and next_attempt <= datetime('now')
+		order by id limit $1
+	`
+	DIAL_CALLBACK_STMT = `
+		UPDATE callback set status = 'dialing', attempts = attempts + 1, dialed_at = datetime('now')
+		where id = $1 and status = 'pending'
+	`
+	// Callback with retryable result goes back to pending state until attempts are over
+	CALLBACK_RESULT_STMT = `
+		UPDATE callback set
+			status = CASE WHEN :retry AND attempts < :max_attempts THEN 'pending' ELSE :status END,
+			next_attempt = datetime('now', '+' || :retry_interval || ' seconds'),
+			last_result = :result
+		where id = :id and status = 'dialing'
+	`
+	// Phone number is dialed without leading +
+	SELECT_DIALING_CALLBACK_STMT = `
+		SELECT * FROM callback where ltrim(phone_number, '+') = ltrim($1, '+') and status = 'dialing'
+	`
+	STUCK_CALLBACKS_STMT = `
+		SELECT id FROM callback
+		where status = 'dialing' and dialed_at <= datetime('now', '-' || $1 || ' seconds')
+	`
+)
+
+type Callback struct {
+	ID          int            `db:"id" json:"id"`
+	PhoneNumber string         `db:"phone_number" json:"phone_number"`
+	Country     string         `db:"country" json:"country"`
+	Status      string         `db:"status" json:"status"`
+	Attempts    int            `db:"attempts" json:"attempts"`
+	NextAttempt string         `db:"next_attempt" json:"next_attempt"`
+	DialedAt    sql.NullString `db:"dialed_at" json:"-"`
+	LastResult  string         `db:"last_result" json:"last_result"`
+	Created     string         `db:"created" json:"created"`
+}
+
+// AddCallback saves callback request, which will be dialed after delay in seconds.
+// If there is already active request for the phone number it is returned instead
+func (db *DBWrapper) AddCallback(phoneNumber, country string, delay int) (Callback, bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	tx, err := db.Beginx()
+	if err != nil {
+		return Callback{}, false, err
+	}
+	defer tx.Rollback()
+
+	callback := Callback{}
+	err = tx.Get(&callback, SELECT_ACTIVE_CALLBACK_STMT, phoneNumber)
+	if err == nil {
+		return callback, false, nil
+	} else if err != sql.ErrNoRows {
+		return callback, false, err
+	}
+
+	res, err := tx.Exec(INSERT_CALLBACK_STMT, phoneNumber, country, delay)
+	if err != nil {
+		return callback, false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return callback, false, err
+	}
+	if err = tx.Get(&callback, "SELECT * FROM callback where id=$1", id); err != nil {
+		return callback, false, err
+	}
+	return callback, true, tx.Commit()
+}
+
+func (db *DBWrapper) GetCallback(id int) (Callback, error) {
+	db.Lock()
+	defer db.Unlock()
+	callback := Callback{}
+	err := db.Get(&callback, "SELECT * FROM callback where id=$1", id)
+	return callback, err
+}
+
+// GetDialingCallback returns callback of phone number which is being dialed
+func (db *DBWrapper) GetDialingCallback(phoneNumber string) (Callback, error) {
+	db.Lock()
+	defer db.Unlock()
+	callback := Callback{}
+	err := db.Get(&callback, SELECT_DIALING_CALLBACK_STMT, phoneNumber)
+	return callback, err
+}
+
+// SelectCallbacks returns the latest callback requests of phone number
+func (db *DBWrapper) SelectCallbacks(phoneNumber string, limit int) ([]Callback, error) {
+	db.Lock()
+	defer db.Unlock()
+	callbacks := []Callback{}
+	err := db.Select(&callbacks,
+		"SELECT * FROM callback where phone_number=$1 order by id desc limit $2", phoneNumber, limit)
+	return callbacks, err
+}
+
+func (db *DBWrapper) SelectDueCallbacks(limit int) ([]Callback, error) {
+	db.Lock()
+	defer db.Unlock()
+	callbacks := []Callback{}
+	err := db.Select(&callbacks, SELECT_DUE_CALLBACKS_STMT, limit)
+	return callbacks, err
+}
+
+// DialCallback marks callback as dialing, returns false if it is not pending anymore
+func (db *DBWrapper) DialCallback(id int) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	res, err := db.Exec(DIAL_CALLBACK_STMT, id)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count == 1, err
+}
+
+// SetCallbackResult saves result of dialing. If result is retryable callback
+// will be dialed again after retry interval, until attempts are over
+func (db *DBWrapper) SetCallbackResult(id int, result, status string, retry bool,
+	maxAttempts, retryInterval int) (sql.Result, error) {
+	db.Lock()
+	defer db.Unlock()
+	return namedExec(CALLBACK_RESULT_STMT, map[string]interface{}{
+		"id":             id,
+		"result":         result,
+		"status":         status,
+		"retry":          retry,
+		"max_attempts":   maxAttempts,
+		"retry_interval": retryInterval,
+	})
+}
+
+// SelectStuckCallbacks returns callbacks which are dialed for too long,
+// most likely events about them were lost
+func (db *DBWrapper) SelectStuckCallbacks(timeout int) ([]int, error) {
+	db.Lock()
+	defer db.Unlock()
+	ids := []int{}
+	err := db.Select(&ids, STUCK_CALLBACKS_STMT, timeout)
+	return ids, err
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"database/sql"
+)
+
+const (
+	CAMPAIGN_ACTIVE   = "active"
+	CAMPAIGN_FINISHED = "finished"
+
+	NUMBER_PENDING  = "pending"
+	NUMBER_DIALING  = "dialing"
+	NUMBER_ANSWERED = "answered"
+	NUMBER_FAILED   = "failed"
+
+	INSERT_CAMPAIGN_STMT = `
+		INSERT INTO campaign (
+			name, country, queue, hours_from, hours_to, max_concurrent, max_attempts, retry_interval, status
+		) values (
+			:name, :country, :queue, :hours_from, :hours_to, :max_concurrent, :max_attempts, :retry_interval,
+			:status
+		)
+	`
+	INSERT_CAMPAIGN_NUMBER_STMT = `
+		INSERT INTO campaign_number (campaign_id, phone_number, status) values ($1, $2, $3)
+	`
+	SELECT_DUE_NUMBERS_STMT = `
+		SELECT * FROM campaign_number
+		where campaign_id = $1 and status = $2 and next_attempt <= datetime('now')
+		order by id limit $3
+	`
+	DIAL_NUMBER_STMT = `
+		UPDATE campaign_number set status = $1, attempts = attempts + 1, dialed_at = datetime('now')
+		where id = $2
+	`
+	// Number with retryable result goes back to pending state until attempts are over
+	NUMBER_RESULT_STMT = `
+		UPDATE campaign_number set
+			status = CASE
+				WHEN :retry AND attempts < (
+					SELECT max_attempts FROM campaign WHERE campaign.id = campaign_number.campaign_id
+				) THEN 'pending'
+				ELSE :status
+			END,
+			next_attempt = datetime('now', '+' || (
+				SELECT retry_interval FROM campaign WHERE campaign.id = campaign_number.campaign_id
+			) || ' seconds'),
+			last_result = :result
+		where id = :id and status = 'dialing'
+	`
+	STUCK_NUMBERS_STMT = `
+		SELECT id FROM campaign_number
+		where status = 'dialing' and dialed_at <= datetime('now', '-' || $1 || ' seconds')
+	`
+	FINISH_CAMPAIGNS_STMT = `
+		UPDATE campaign set status = 'finished'
+		where status = 'active' and not exists (
+			SELECT 1 FROM campaign_number
+			where campaign_number.campaign_id = campaign.id and status in ('pending', 'dialing')
+		)
+	`
+)
+
+type Campaign struct {
+	ID            int    `db:"id" json:"id"`
+	Name          string `db:"name" json:"name"`
+	Country       string `db:"country" json:"country"`
+	Queue         string `db:"queue" json:"queue"`
+	HoursFrom     string `db:"hours_from" json:"hours_from"`
+	HoursTo       string `db:"hours_to" json:"hours_to"`
+	MaxConcurrent int    `db:"max_concurrent" json:"max_concurrent"`
+	MaxAttempts   int    `db:"max_attempts" json:"max_attempts"`
+	RetryInterval int    `db:"retry_interval" json:"retry_interval"`
+	Status        string `db:"status" json:"status"`
+	Created       string `db:"created" json:"created"`
+}
+
+type CampaignNumber struct {
+	ID          int            `db:"id" json:"id"`
+	CampaignID  int            `db:"campaign_id" json:"-"`
+	PhoneNumber string         `db:"phone_number" json:"phone_number"`
+	Status      string         `db:"status" json:"status"`
+	Attempts    int            `db:"attempts" json:"attempts"`
+	NextAttempt string         `db:"next_attempt" json:"next_attempt"`
+	DialedAt    sql.NullString `db:"dialed_at" json:"-"`
+	LastResult  string         `db:"last_result" json:"last_result"`
+}
+
+func (db *DBWrapper) AddCampaign(campaign Campaign, numbers []string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	campaign.Status = CAMPAIGN_ACTIVE
+	res, err := tx.NamedExec(INSERT_CAMPAIGN_STMT, campaign)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, number := range numbers {
+		if _, err := tx.Exec(INSERT_CAMPAIGN_NUMBER_STMT, id, number, NUMBER_PENDING); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+func (db *DBWrapper) GetCampaign(id int) (Campaign, []CampaignNumber, error) {
+	db.Lock()
+	defer db.Unlock()
+	campaign := Campaign{}
+	if err := db.Get(&campaign, "SELECT * FROM campaign where id=$1", id); err != nil {
+		return campaign, nil, err
+	}
+	numbers := []CampaignNumber{}
+	err := db.Select(&numbers, "SELECT * FROM campaign_number where campaign_id=$1 order by id", id)
+	return campaign, numbers, err
+}
+
+func (db *DBWrapper) SelectActiveCampaigns() ([]Campaign, error) {
+	db.Lock()
+	defer db.Unlock()
+	campaigns := []Campaign{}
+	err := db.Select(&campaigns, "SELECT * FROM campaign where status=$1 order by id",
+		CAMPAIGN_ACTIVE)
+	return campaigns, err
+}
+
+func (db *DBWrapper) CountDialingNumbers(campaignId int) (result int) {
+	db.Lock()
+	defer db.Unlock()
+	db.Get(&result, "SELECT count(*) FROM campaign_number where campaign_id=$1 and status=$2",
+		campaignId, NUMBER_DIALING)
+	return
+}
+
+// TakeCampaignNumbers returns numbers of campaign which are due to be dialed and
+// marks them as dialing
+func (db *DBWrapper) TakeCampaignNumbers(campaignId, limit int) ([]CampaignNumber, error) {
+	db.Lock()
+	defer db.Unlock()
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	numbers := []CampaignNumber{}
+	err = tx.Select(&numbers, SELECT_DUE_NUMBERS_STMT, campaignId, NUMBER_PENDING, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range numbers {
+		if _, err := tx.Exec(DIAL_NUMBER_STMT, NUMBER_DIALING, number.ID); err != nil {
+			return nil, err
+		}
+	}
+	return numbers, tx.Commit()
+}
+
+// SetCampaignNumberResult saves result of dialing. If result is retryable
+// number will be dialed again after retry interval of its campaign
+func (db *DBWrapper) SetCampaignNumberResult(id int, result, status string, retry bool) (
+	sql.Result, error) {
+	db.Lock()
+	defer db.Unlock()
+	return namedExec(NUMBER_RESULT_STMT, map[string]interface{}{
+		"id":     id,
+		"result": result,
+		"status": status,
+		"retry":  retry,
+	})
+}
+
+// SelectStuckCampaignNumbers returns numbers which are dialed for too long,
+// most likely events about them were lost
+func (db *DBWrapper) SelectStuckCampaignNumbers(timeout int) ([]int, error) {
+	db.Lock()
+	defer db.Unlock()
+	ids := []int{}
+	err := db.Select(&ids, STUCK_NUMBERS_STMT, timeout)
+	return ids, err
+}
+
+func (db *DBWrapper) FinishCampaigns() (sql.Result, error) {
+	db.Lock()
+	defer db.Unlock()
+	return namedExec(FINISH_CAMPAIGNS_STMT, map[string]interface{}{})
+}
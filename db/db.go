@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/jmoiron/sqlx"
@@ -17,10 +18,10 @@ const (
 	INSERT_CDR_STMT = `
 		INSERT INTO cdr (
 			status, caller_id, unique_id, inner_phone_number, opponent_phone_number, call_type, company_id, disposition,
-			start_time, billable_seconds, country_code
+			start_time, answer_time, end_time, billable_seconds, country_code
 		) values (
 			0, :caller_id, :unique_id, :inner_phone_number, :opponent_phone_number, :call_type, :company_id,
-			:disposition, :start_time, :billable_seconds, :country_code
+			:disposition, :start_time, :answer_time, :end_time, :billable_seconds, :country_code
 		)
 	`
 	INSER_PC_STMT   = "INSERT OR IGNORE INTO phone_call (unique_id) VALUES (:unique_id)"
@@ -29,8 +30,25 @@ const (
 	DELETE_CDR_STMT = "UPDATE cdr set status = 1 where id=:id"
 	COUNT_CDR_STMT  = "SELECT count(*) from cdr where status = 0"
 	COUNT_PC_STMT   = "SELECT count(*) from phone_call"
+
+	CDR_BACKLOG_STMT = "SELECT country_code, count(*) from cdr where status = 0 group by country_code"
+	OLDEST_CDR_STMT  = "SELECT start_time from cdr where status = 0 order by id limit 1"
+
+	INSERT_SPY_STMT = `
+		INSERT INTO spy_session (supervisor, target, mode) values (:supervisor, :target, :mode)
+	`
+	FINISH_SPY_STMT = `
+		UPDATE spy_session set end_time = CURRENT_TIMESTAMP where id=:id and end_time is null
+	`
 )
 
+// Migrations of tables created by older versions, columns which already
+// exist are skipped
+var migrations = []string{
+	"ALTER TABLE cdr ADD COLUMN answer_time text not null default ''",
+	"ALTER TABLE cdr ADD COLUMN end_time text not null default ''",
+}
+
 type DBWrapper struct {
 	*sqlx.DB
 	*sync.RWMutex
@@ -52,29 +70,99 @@ var (
 		disposition text not null,
 		start_time text not null,
 		billable_seconds text not null,
-		country_code text  not null
+		country_code text  not null,
+		answer_time text not null default '',
+		end_time text not null default ''
 	);
 
     CREATE TABLE IF NOT EXISTS phone_call (
         id integer PRIMARY KEY AUTOINCREMENT,
         unique_id text UNIQUE
     );
+
+	CREATE TABLE IF NOT EXISTS campaign (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		name text not null,
+		country text not null,
+		queue text not null,
+		hours_from text not null,
+		hours_to text not null,
+		max_concurrent integer not null,
+		max_attempts integer not null,
+		retry_interval integer not null,
+		status text not null,
+		created text not null default CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS campaign_number (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		campaign_id integer not null,
+		phone_number text not null,
+		status text not null,
+		attempts integer not null default 0,
+		next_attempt text not null default CURRENT_TIMESTAMP,
+		dialed_at text,
+		last_result text not null default ''
+	);
+	CREATE INDEX IF NOT EXISTS campaign_number_status ON campaign_number (campaign_id, status);
+
+	CREATE TABLE IF NOT EXISTS callback (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		phone_number text not null,
+		country text not null,
+		status text not null,
+		attempts integer not null default 0,
+		next_attempt text not null default CURRENT_TIMESTAMP,
+		dialed_at text,
+		last_result text not null default '',
+		created text not null default CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS callback_status ON callback (status, next_attempt);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		created text not null default CURRENT_TIMESTAMP,
+		remote_addr text not null,
+		country text not null,
+		route text not null,
+		params text not null,
+		result text not null,
+		status text not null
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_created ON audit_log (created);
+
+	CREATE TABLE IF NOT EXISTS spy_session (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		supervisor text not null,
+		target text not null,
+		mode text not null,
+		start_time text not null default CURRENT_TIMESTAMP,
+		end_time text
+	);
 	`
 )
 
+// CDR is sent to save_phone_call api of portal as json. Times are stored in UTC in
+// RFC3339, but StartTime is sent to portal in UTC in format 2006-01-02 15:04:05, as
+// it always was. AnswerTime, EndTime and LocalStartTime were added later and are sent
+// in RFC3339, LocalStartTime is in time zone of the country
 type CDR struct {
-	ID                  int    `db:"id"`
-	Status              int    `db:"status"`
-	UniqueID            string `db:"unique_id"`
-	CallerID            string `db:"caller_id"`
-	InnerPhoneNumber    string `db:"inner_phone_number"`
-	OpponentPhoneNumber string `db:"opponent_phone_number"`
-	CallType            string `db:"call_type"`
-	CompanyId           string `db:"company_id"`
-	Disposition         string `db:"disposition"`
-	StartTime           string `db:"start_time"`
-	BillableSeconds     string `db:"billable_seconds"`
-	CountryCode         string `db:"country_code"`
+	ID                  int    `db:"id" json:"ID"`
+	Status              int    `db:"status" json:"Status"`
+	UniqueID            string `db:"unique_id" json:"UniqueID"`
+	CallerID            string `db:"caller_id" json:"CallerID"`
+	InnerPhoneNumber    string `db:"inner_phone_number" json:"InnerPhoneNumber"`
+	OpponentPhoneNumber string `db:"opponent_phone_number" json:"OpponentPhoneNumber"`
+	CallType            string `db:"call_type" json:"CallType"`
+	CompanyId           string `db:"company_id" json:"CompanyId"`
+	Disposition         string `db:"disposition" json:"Disposition"`
+	StartTime           string `db:"start_time" json:"StartTime"`
+	AnswerTime          string `db:"answer_time" json:"AnswerTime"`
+	EndTime             string `db:"end_time" json:"EndTime"`
+	BillableSeconds     string `db:"billable_seconds" json:"BillableSeconds"`
+	CountryCode         string `db:"country_code" json:"CountryCode"`
+	// It is not stored and set only for portal
+	LocalStartTime string `db:"-" json:"LocalStartTime"`
 }
 
 type PhoneCall struct {
@@ -82,6 +170,35 @@ type PhoneCall struct {
 	UniqueID string `db:"unique_id"`
 }
 
+type SpySession struct {
+	ID         int            `db:"id"`
+	Supervisor string         `db:"supervisor"`
+	Target     string         `db:"target"`
+	Mode       string         `db:"mode"`
+	StartTime  string         `db:"start_time"`
+	EndTime    sql.NullString `db:"end_time"`
+}
+
+func (db *DBWrapper) AddSpySession(supervisor, target, mode string) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	res, err := namedExec(INSERT_SPY_STMT, SpySession{
+		Supervisor: supervisor,
+		Target:     target,
+		Mode:       mode,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (db *DBWrapper) FinishSpySession(id int) (sql.Result, error) {
+	db.Lock()
+	defer db.Unlock()
+	return namedExec(FINISH_SPY_STMT, map[string]interface{}{"id": id})
+}
+
 func (db *DBWrapper) AddCDR(m map[string]string) (sql.Result, error) {
 	cdr := CDR{
 		UniqueID:            m["UniqueID"],
@@ -92,6 +209,8 @@ func (db *DBWrapper) AddCDR(m map[string]string) (sql.Result, error) {
 		CompanyId:           m["CompanyId"],
 		Disposition:         m["Disposition"],
 		StartTime:           m["StartTime"],
+		AnswerTime:          m["AnswerTime"],
+		EndTime:             m["EndTime"],
 		BillableSeconds:     m["BillableSeconds"],
 		CountryCode:         m["CountryCode"],
 	}
@@ -133,6 +252,35 @@ func (db *DBWrapper) GetCdrCount() (result int) {
 	return
 }
 
+// SelectCdrBacklog returns number of not sent cdr by country
+func (db *DBWrapper) SelectCdrBacklog() (map[string]int, error) {
+	db.Lock()
+	defer db.Unlock()
+	rows, err := db.Query(CDR_BACKLOG_STMT)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	backlog := map[string]int{}
+	for rows.Next() {
+		var country string
+		var count int
+		if err := rows.Scan(&country, &count); err != nil {
+			return nil, err
+		}
+		backlog[country] = count
+	}
+	return backlog, rows.Err()
+}
+
+// GetOldestCdrTime returns start time of the oldest not sent cdr, empty if there is none
+func (db *DBWrapper) GetOldestCdrTime() (result string) {
+	db.Lock()
+	defer db.Unlock()
+	db.Get(&result, OLDEST_CDR_STMT)
+	return
+}
+
 func (db *DBWrapper) GetPhoneCallCount() (result int) {
 	db.Lock()
 	defer db.Unlock()
@@ -194,6 +342,12 @@ func GetDB() *DBWrapper {
 		connector := sqlx.MustConnect("sqlite3",
 			filepath.Join(path, conf.CDR_DB_FILE))
 		connector.MustExec(schema)
+		for _, migration := range migrations {
+			if _, err := connector.Exec(migration); err != nil &&
+				!strings.Contains(err.Error(), "duplicate column name") {
+				panic(err)
+			}
+		}
 		db = &DBWrapper{connector, new(sync.RWMutex)}
 	})
 	return db
@@ -4,19 +4,74 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/warik/gami"
+	"github.com/warik/go-dialer/alert"
 	"github.com/warik/go-dialer/ami"
 	"github.com/warik/go-dialer/conf"
 	"github.com/warik/go-dialer/db"
+	"github.com/warik/go-dialer/logger"
+	"github.com/warik/go-dialer/metrics"
+	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/presence"
 	"github.com/warik/go-dialer/util"
 )
 
-var callsCache = util.NewSafeMap()
+var (
+	cdrLog      = logger.New("cdr")
+	bridgeLog   = logger.New("bridge")
+	presenceLog = logger.New("presence")
+
+	callsCache = util.NewSafeMap()
+	// Last states of calls placed from CRM, by call id and party
+	crmCallsStates = util.NewSafeMap()
+	// Countries which placed calls from CRM, by call id
+	crmCallsCountries = util.NewSafeMap()
+
+	// Asterisk dial statuses of customer leg
+	DIAL_STATES = map[string]string{
+		"ANSWER":      CALL_ANSWERED,
+		"BUSY":        CALL_BUSY,
+		"NOANSWER":    CALL_NO_ANSWER,
+		"CANCEL":      CALL_FAILED,
+		"CONGESTION":  CALL_FAILED,
+		"CHANUNAVAIL": CALL_FAILED,
+	}
+	// Reasons of failed originate for manager leg
+	ORIGINATE_REASONS = map[string]string{
+		"1": CALL_NO_ANSWER,
+		"3": CALL_NO_ANSWER,
+		"5": CALL_BUSY,
+	}
+)
+
+const (
+	CALL_RINGING   = "ringing"
+	CALL_ANSWERED  = "answered"
+	CALL_BUSY      = "busy"
+	CALL_NO_ANSWER = "no-answer"
+	CALL_FAILED    = "failed"
+
+	MANAGER_PARTY  = "manager"
+	CUSTOMER_PARTY = "customer"
+)
+
+const CAMPAIGN_CALLER_ID_PREFIX = "campaign:"
+
+var (
+	CALL_STATUS_EVENTS = []string{"OriginateResponse", "Newstate", "DialBegin", "DialEnd", "Hangup"}
+	CAMPAIGN_EVENTS    = []string{"OriginateResponse", "DialEnd", "Hangup"}
+	CALLBACK_EVENTS    = []string{"OriginateResponse", "DialEnd", "Hangup"}
+	SPY_EVENTS         = []string{"OriginateResponse", "Hangup"}
+)
 
 func CdrEventHandler(m gami.Message) {
-	glog.Infoln("<<< INCOMING CDR", m)
+	log := cdrLog.With(logger.Fields{"unique_id": m["UniqueID"]})
+	log.Info("Cdr received", logger.Fields{"event": m})
+	metrics.CdrReceived.Inc()
 
 	var innerNumber, outerNumber string
 	var callType int
@@ -33,24 +88,30 @@ func CdrEventHandler(m gami.Message) {
 	}
 
 	if callType == util.INNER_CALL || callType == -1 {
-		glog.Errorln("<<< BAD CDR", callType)
+		log.Error("Bad cdr", logger.Fields{"call_type": callType})
+		metrics.CdrRejected.WithLabelValues(metrics.BAD_CDR).Inc()
 		return
 	}
 
 	countryCode := util.GetCountryByPhones(innerNumber, outerNumber)
+	log = log.With(logger.Fields{"inner_number": innerNumber, "opponent_number": outerNumber,
+		"country": countryCode})
 	if _, ok := conf.GetConf().Agencies[countryCode]; countryCode == "" || !ok {
-		glog.Errorln("Unexisting numbers...", innerNumber, outerNumber,
-			countryCode)
+		log.Error("Unexisting numbers")
+		metrics.CdrRejected.WithLabelValues(metrics.UNEXISTING_NUMBERS).Inc()
 		return
 	}
 
 	if !util.IsNumbersValid(innerNumber, outerNumber, countryCode) {
-		glog.Errorln("Wrong numbers...", innerNumber, outerNumber, countryCode)
+		log.Error("Wrong numbers")
+		metrics.CdrRejected.WithLabelValues(metrics.WRONG_NUMBERS).Inc()
 		return
 	}
-	// We may receive time from asterisk with time zone offset
-	// but for correct processing of it on portal side we need to store it in raw GMT
+	// We receive time from asterisk in its time zone, but for correct processing
+	// of it on portal side we need to store it in UTC
 	m["StartTime"] = util.ConvertTime(m["StartTime"])
+	m["AnswerTime"] = util.ConvertTime(m["AnswerTime"])
+	m["EndTime"] = util.ConvertTime(m["EndTime"])
 	m["InnerPhoneNumber"] = innerNumber
 	m["OpponentPhoneNumber"] = outerNumber
 	m["CallType"] = strconv.Itoa(callType)
@@ -59,23 +120,25 @@ func CdrEventHandler(m gami.Message) {
 
 	_, err := db.GetDB().AddCDR(m)
 	if err != nil {
-		conf.Alert(err.Error())
-		glog.Errorln(err)
+		alert.Raise("cdr_save", alert.CRITICAL, fmt.Sprintf("Cannot save cdr | %s", err))
+		log.Error("Cannot save cdr", logger.Fields{"error": err})
+	} else {
+		metrics.CdrStored.Inc()
+		log.Info("Cdr saved")
 	}
-	glog.Infoln("<<< SAVED CDR", m["UniqueID"])
 
 	// For future phone call record convertion and sending we need to be sure
 	// that it exists
 	sec, _ := strconv.Atoi(m["BillableSeconds"])
-	badCallFromCRM := strings.Contains(m["CallerID"], "call_from_CRM") && sec <= 10
+	badCallFromCRM := strings.Contains(m["CallerID"], model.CALL_FROM_CRM) && sec <= 10
 	if sec == 0 || m["Disposition"] != "ANSWERED" || badCallFromCRM {
 		return
 	}
 
 	_, err = db.GetDB().AddPhoneCall(m["UniqueID"])
 	if err != nil {
-		conf.Alert(err.Error())
-		glog.Errorln(err)
+		alert.Raise("phone_call_save", alert.CRITICAL, fmt.Sprintf("Cannot save phone call | %s", err))
+		log.Error("Cannot save phone call", logger.Fields{"error": err})
 	}
 
 	// Also after each successful call we need to show manager popup with
@@ -83,9 +146,9 @@ func CdrEventHandler(m gami.Message) {
 	reviewHref := conf.GetConf().GetReviewUri(m["UniqueID"])
 	resp, err := util.ShowReviewPopup(reviewHref, innerNumber, countryCode)
 	if err != nil {
-		glog.Errorln(err)
+		log.Error("Cannot show review popup", logger.Fields{"error": err})
 	} else {
-		glog.Infoln("Show review popup response", resp)
+		log.Info("Review popup shown", logger.Fields{"response": resp})
 	}
 }
 
@@ -94,23 +157,26 @@ func BridgeEventHandler(m gami.Message) {
 	// But for showing popup we need exactly second one "BridgeNumChannels: 2"
 	// And by check that ConnectedLineNum have length more than 4 digits
 	// we can tell that this is incoming call (because cally number is big)
+	log := bridgeLog.With(logger.Fields{"unique_id": m["Uniqueid"], "channel": m["Channel"]})
 	callingPhone := m["ConnectedLineNum"]
 	if *showPopups && m["BridgeNumChannels"] == "2" && len(callingPhone) > 4 {
 		innerNumberArr := util.PHONE_RE.FindStringSubmatch(m["Channel"])
 		if innerNumberArr == nil {
-			glog.Errorln("Bad bridge event", m)
+			log.Error("Bad bridge event", logger.Fields{"event": m})
 			return
 		}
 		innerNumber := innerNumberArr[1]
 		country := util.GetCountryByPhones(innerNumber, callingPhone)
+		log := log.With(logger.Fields{"inner_number": innerNumber, "opponent_number": callingPhone,
+			"country": country})
 		if country == "" {
-			glog.Errorln("Unexisting numbers...", innerNumber, callingPhone)
+			log.Error("Unexisting numbers")
 		}
 		resp, err := util.ShowCallingPopup(innerNumber, callingPhone, country)
 		if err != nil {
-			glog.Errorln(err)
+			log.Error("Cannot show calling popup", logger.Fields{"error": err})
 		} else {
-			glog.Infoln("Show calling popup response", resp)
+			log.Info("Calling popup shown", logger.Fields{"response": resp})
 		}
 	}
 
@@ -123,15 +189,327 @@ func BridgeEventHandler(m gami.Message) {
 	// 	return
 	// }
 	// callsCache.Map[channel] = struct{}{}
-	glog.Infoln(m)
+	log.Debug("Bridge event", logger.Fields{"event": m})
 	if *savePhoneCalls && m["BridgeNumChannels"] == "1" {
 		fileName := util.GetPhoneCallFileName(conf.GetConf().Name, m["Uniqueid"], "wav")
 		fullFileName := fmt.Sprintf("%s/%s", conf.GetConf().FolderForCalls, fileName)
 		_, err := ami.SendMixMonitor(m["Channel"], fullFileName)
 		if err != nil {
-			glog.Errorln(err)
+			log.Error("Cannot start MixMonitor", logger.Fields{"error": err})
+		} else {
+			log.Info("MixMonitor started", logger.Fields{"file": fileName})
+		}
+	}
+}
+
+func PresenceEventHandler(m gami.Message) {
+	p, changed := presence.Update(m)
+	if !changed || !*pushPresence {
+		return
+	}
+	// Duplicated number should be reported to each portal it belongs to
+	for _, country := range util.GetCountriesByNumber(p.InnerNumber) {
+		log := presenceLog.With(logger.Fields{"inner_number": p.InnerNumber, "state": p.State,
+			"country": country})
+		resp, err := util.SendPresence(p.InnerNumber, p.State, country)
+		if err != nil {
+			log.Error("Cannot send presence", logger.Fields{"error": err})
+		} else {
+			log.Info("Presence sent", logger.Fields{"response": resp})
+		}
+	}
+}
+
+// SpyEventHandler finishes spy session when supervisor hangs up, or when its
+// originate failed, since the failure of async originate comes only as event
+func SpyEventHandler(m gami.Message) {
+	if !strings.HasPrefix(m["CallerIDName"], SPY_CALLER_ID_PREFIX) {
+		return
+	}
+	if m["Event"] == "OriginateResponse" && m["Response"] == "Success" {
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(m["CallerIDName"], SPY_CALLER_ID_PREFIX))
+	if err != nil {
+		glog.Errorln("Bad spy session id", m["CallerIDName"])
+		return
+	}
+	if _, err := db.GetDB().FinishSpySession(id); err != nil {
+		glog.Errorln(err)
+	} else {
+		glog.Infoln("<<< SPY SESSION FINISHED", id)
+	}
+}
+
+// CallStatusEventHandler follows calls placed from CRM by their caller id and
+// reports states of manager and customer legs to portal. Manager is called first
+// by originate, customer is dialed after manager answers
+func CallStatusEventHandler(m gami.Message) {
+	if !strings.HasPrefix(m["CallerIDName"], model.CALL_FROM_CRM) {
+		return
+	}
+	crmCallId := strings.TrimPrefix(m["CallerIDName"], model.CALL_FROM_CRM)
+	innerNumber := m["CallerIDNum"]
+	// Manager is called by originate, so channel of inner number is the originated one,
+	// other channels of the call, like trunk of customer, have the same caller id
+	isManagerChannel := false
+	if innerNumberArr := util.PHONE_RE.FindStringSubmatch(m["Channel"]); innerNumberArr != nil {
+		isManagerChannel = innerNumberArr[1] == innerNumber
+	}
+
+	var party, state, phone string
+	switch m["Event"] {
+	case "OriginateResponse":
+		party = MANAGER_PARTY
+		if m["Response"] == "Success" {
+			state = CALL_ANSWERED
+		} else if reason, ok := ORIGINATE_REASONS[m["Reason"]]; ok {
+			state = reason
 		} else {
-			glog.Infoln("MixMonitor sent...", fileName)
+			state = CALL_FAILED
+		}
+	case "Newstate":
+		if m["ChannelStateDesc"] == "Ringing" && isManagerChannel {
+			party, state = MANAGER_PARTY, CALL_RINGING
 		}
+	case "DialBegin":
+		party, state, phone = CUSTOMER_PARTY, CALL_RINGING, m["DestCallerIDNum"]
+	case "DialEnd":
+		party, phone = CUSTOMER_PARTY, m["DestCallerIDNum"]
+		if dialState, ok := DIAL_STATES[m["DialStatus"]]; ok {
+			state = dialState
+		}
+	case "Hangup":
+		// Call is over when originated channel hangs up, so its states are not needed anymore
+		if isManagerChannel {
+			forgetCrmCall(crmCallId)
+		}
+		return
+	}
+	if state == "" {
+		return
+	}
+
+	// Several channels may report the same state, so send only changes. Events are
+	// handled concurrently, so ringing may come after manager answered
+	key := crmCallId + "/" + party
+	crmCallsStates.Lock()
+	if last := crmCallsStates.Map[key]; last == state || last == CALL_ANSWERED && state == CALL_RINGING {
+		crmCallsStates.Unlock()
+		return
+	}
+	crmCallsStates.Map[key] = state
+	crmCallsStates.Unlock()
+
+	country := getCrmCallCountry(crmCallId, innerNumber, phone)
+	// Failed originate is the last event of the call
+	if m["Event"] == "OriginateResponse" && m["Response"] != "Success" {
+		forgetCrmCall(crmCallId)
+	}
+
+	glog.Infoln("<<< CRM CALL STATE", crmCallId, party, state)
+	if conf.GetConf().CallStatusApi == "" {
+		return
+	}
+	if country == "" {
+		glog.Errorln("Unexisting numbers...", innerNumber, phone)
+		return
+	}
+	resp, err := util.SendCallStatus(crmCallId, innerNumber, phone, party, state, country)
+	if err != nil {
+		glog.Errorln(err)
+	} else {
+		glog.Infoln("Send call status response", resp)
+	}
+}
+
+// getCrmCallCountry returns country which placed the call from CRM. If it is not
+// known, country is guessed by numbers, which is not possible for inner number
+// duplicated in several countries until customer is dialed
+func getCrmCallCountry(crmCallId, innerNumber, phone string) string {
+	crmCallsCountries.RLock()
+	country, _ := crmCallsCountries.Map[crmCallId].(string)
+	crmCallsCountries.RUnlock()
+	if country != "" {
+		return country
+	}
+	return util.GetCountryByPhones(innerNumber, phone)
+}
+
+func forgetCrmCall(crmCallId string) {
+	crmCallsStates.Remove(crmCallId + "/" + MANAGER_PARTY)
+	crmCallsStates.Remove(crmCallId + "/" + CUSTOMER_PARTY)
+	crmCallsCountries.Remove(crmCallId)
+}
+
+// getDialResult returns result of call to number from campaign or callback request.
+// Managers from queue are called first, and then the one who answered is connected
+// with the number, so only dial of the number tells if it answered
+func getDialResult(m gami.Message) string {
+	switch m["Event"] {
+	case "OriginateResponse":
+		if m["Response"] == "Success" {
+			return ""
+		} else if reason, ok := ORIGINATE_REASONS[m["Reason"]]; ok {
+			return reason
+		}
+		return CALL_FAILED
+	case "DialEnd":
+		// Queue dials managers with the same caller id, skip those dials
+		if innerNumberArr := util.PHONE_RE.FindStringSubmatch(m["DestChannel"]); innerNumberArr != nil &&
+			len(util.GetCountriesByNumber(innerNumberArr[1])) > 0 {
+			return ""
+		}
+		if dialState, ok := DIAL_STATES[m["DialStatus"]]; ok {
+			return dialState
+		}
+		return CALL_FAILED
+	case "Hangup":
+		// Queue rings managers with the same caller id, so only hangup of originated
+		// channel tells that call ended without any dial result. It is saved only
+		// if number is still dialing
+		if strings.HasPrefix(m["Channel"], "Local/") && strings.HasSuffix(m["Channel"], ";1") {
+			return CALL_NO_ANSWER
+		}
+	}
+	return ""
+}
+
+// getDialStatus returns state of dialed number by result of the call and
+// whether it should be dialed again
+func getDialStatus(result string) (string, bool) {
+	if result == CALL_ANSWERED {
+		return db.NUMBER_ANSWERED, false
+	}
+	return db.NUMBER_FAILED, result == CALL_BUSY || result == CALL_NO_ANSWER
+}
+
+// getTaggedId returns id from caller id name like campaign:12
+func getTaggedId(m gami.Message, prefix string) (int, bool) {
+	if !strings.HasPrefix(m["CallerIDName"], prefix) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(m["CallerIDName"], prefix))
+	if err != nil {
+		campaignLog.Error("Bad id in caller id", logger.Fields{"caller_id_name": m["CallerIDName"]})
+		return 0, false
+	}
+	return id, true
+}
+
+func CampaignEventHandler(m gami.Message) {
+	id, ok := getTaggedId(m, CAMPAIGN_CALLER_ID_PREFIX)
+	if !ok {
+		return
+	}
+	result := getDialResult(m)
+	if result == "" {
+		return
+	}
+	status, retry := getDialStatus(result)
+	log := campaignLog.With(logger.Fields{"number_id": id, "result": result})
+	res, err := db.GetDB().SetCampaignNumberResult(id, result, status, retry)
+	if err != nil {
+		log.Error("Cannot save campaign number result", logger.Fields{"error": err})
+	} else if count, _ := res.RowsAffected(); count == 1 {
+		log.Info("Campaign number result saved")
+	}
+}
+
+// Callback dials by unique id of originated channel
+var callbackDials = struct {
+	sync.Mutex
+	m map[string]*callbackDial
+}{m: map[string]*callbackDial{}}
+
+type callbackDial struct {
+	id      int
+	result  string
+	hungUp  bool
+	saved   bool
+	created time.Time
+}
+
+// CallBackEventHandler saves results of callback calls. They all have the same
+// caller id, so callback is found by number of originate and then its result is
+// taken from events of originated channel only. Events are handled concurrently,
+// so hangup without dial result is saved as no answer only if dial result does
+// not come in CALLBACK_HANGUP_WAIT
+func CallBackEventHandler(m gami.Message) {
+	if fmt.Sprintf("%s <%s>", m["CallerIDName"], m["CallerIDNum"]) != ami.CALLBACK_CALLER_ID {
+		return
+	}
+	uniqueId := m["Uniqueid"]
+	switch m["Event"] {
+	case "OriginateResponse":
+		callback, err := db.GetDB().GetDialingCallback(m["Exten"])
+		if err != nil {
+			callbackLog.Error("Cannot find dialing callback",
+				logger.Fields{"phone_number": m["Exten"], "error": err})
+			return
+		}
+		if m["Response"] != "Success" {
+			SaveCallBackResult(callback.ID, getDialResult(m))
+			return
+		}
+		updateCallbackDial(uniqueId, true, func(d *callbackDial) { d.id = callback.ID })
+	case "DialEnd", "Hangup":
+		// Queue and managers legs are linked to originated channel
+		if uniqueId == "" || uniqueId != m["Linkedid"] {
+			return
+		}
+		if m["Event"] == "DialEnd" {
+			if result := getDialResult(m); result != "" {
+				updateCallbackDial(uniqueId, true, func(d *callbackDial) {
+					if d.result == "" {
+						d.result = result
+					}
+				})
+			}
+			return
+		}
+		updateCallbackDial(uniqueId, true, func(d *callbackDial) { d.hungUp = true })
+		time.AfterFunc(conf.CALLBACK_HANGUP_WAIT, func() {
+			updateCallbackDial(uniqueId, false, func(d *callbackDial) {
+				if d.result == "" {
+					d.result = CALL_NO_ANSWER
+				}
+			})
+		})
+	}
+}
+
+// updateCallbackDial changes dial of originated channel and saves its result as soon
+// as both callback and result are known. Dial is forgotten after hangup when its
+// result is saved
+func updateCallbackDial(uniqueId string, create bool, update func(d *callbackDial)) {
+	callbackDials.Lock()
+	now := time.Now()
+	for key, d := range callbackDials.m {
+		if now.Sub(d.created) > conf.CALLBACK_DIAL_TIMEOUT*time.Second {
+			delete(callbackDials.m, key)
+		}
+	}
+	d, ok := callbackDials.m[uniqueId]
+	if !ok && !create {
+		callbackDials.Unlock()
+		return
+	} else if !ok {
+		d = &callbackDial{created: now}
+		callbackDials.m[uniqueId] = d
+	}
+	update(d)
+	save := d.id != 0 && d.result != "" && !d.saved
+	if save {
+		d.saved = true
+	}
+	if d.saved && d.hungUp {
+		delete(callbackDials.m, uniqueId)
+	}
+	id, result := d.id, d.result
+	callbackDials.Unlock()
+
+	if save {
+		SaveCallBackResult(id, result)
 	}
 }
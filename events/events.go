@@ -0,0 +1,134 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/warik/gami"
+
+	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/util"
+)
+
+const (
+	RINGING     = "ringing"
+	ANSWERED    = "answered"
+	BRIDGED     = "bridged"
+	HANGUP      = "hangup"
+	QUEUE_JOIN  = "queue_join"
+	QUEUE_LEAVE = "queue_leave"
+)
+
+var (
+	// AMI events from which call lifecycle events are built
+	AMI_EVENTS = []string{"Newstate", "BridgeEnter", "Hangup", "QueueCallerJoin", "QueueCallerLeave"}
+
+	subscribers = struct {
+		sync.RWMutex
+		m map[*Subscription]struct{}
+	}{m: map[*Subscription]struct{}{}}
+)
+
+type Subscription struct {
+	C      chan model.CallEvent
+	filter model.EventsFilter
+}
+
+func Subscribe(filter model.EventsFilter) *Subscription {
+	s := &Subscription{make(chan model.CallEvent, conf.EVENTS_BUFFER_SIZE), filter}
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	subscribers.m[s] = struct{}{}
+	return s
+}
+
+func Unsubscribe(s *Subscription) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	if _, ok := subscribers.m[s]; ok {
+		delete(subscribers.m, s)
+		close(s.C)
+	}
+}
+
+// Close drops all subscriptions, so streaming handlers can finish on shutdown
+func Close() {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	for s := range subscribers.m {
+		delete(subscribers.m, s)
+		close(s.C)
+	}
+}
+
+func Publish(e model.CallEvent) {
+	subscribers.RLock()
+	defer subscribers.RUnlock()
+	for s := range subscribers.m {
+		if !s.filter.Match(e) {
+			continue
+		}
+		// Slow subscriber should not block asterisk events processing
+		select {
+		case s.C <- e:
+		default:
+			glog.Warningln("Events subscriber is too slow, dropping event", e.Type, e.UniqueId)
+		}
+	}
+}
+
+// AMIEventHandler normalizes asterisk event and publishes it to subscribers
+func AMIEventHandler(m gami.Message) {
+	if e, ok := FromAMI(m); ok {
+		Publish(e)
+	}
+}
+
+func FromAMI(m gami.Message) (model.CallEvent, bool) {
+	e := model.CallEvent{UniqueId: m["Uniqueid"], Queue: m["Queue"], Time: time.Now().UTC()}
+	switch m["Event"] {
+	case "Newstate":
+		switch m["ChannelStateDesc"] {
+		case "Ringing":
+			e.Type = RINGING
+		case "Up":
+			e.Type = ANSWERED
+		default:
+			return e, false
+		}
+	case "BridgeEnter":
+		e.Type = BRIDGED
+	case "Hangup":
+		e.Type = HANGUP
+	case "QueueCallerJoin":
+		e.Type = QUEUE_JOIN
+	case "QueueCallerLeave":
+		e.Type = QUEUE_LEAVE
+	default:
+		return e, false
+	}
+
+	callerId, connectedLine := cleanNumber(m["CallerIDNum"]), cleanNumber(m["ConnectedLineNum"])
+	// Inner number is either in the channel name or, if event came for
+	// the opponent channel, in its connected line
+	if innerNumberArr := util.PHONE_RE.FindStringSubmatch(m["Channel"]); innerNumberArr != nil {
+		e.InnerNumber, e.OpponentNumber = innerNumberArr[1], connectedLine
+	} else if util.GetCountryByPhones(connectedLine, callerId) != "" {
+		e.InnerNumber, e.OpponentNumber = connectedLine, callerId
+	} else {
+		e.OpponentNumber = callerId
+	}
+	if e.InnerNumber != "" {
+		e.Country = util.GetCountryByPhones(e.InnerNumber, e.OpponentNumber)
+	}
+	return e, true
+}
+
+func cleanNumber(number string) string {
+	if number == "<unknown>" {
+		return ""
+	}
+	return number
+}
@@ -2,22 +2,51 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
 	"github.com/warik/gami"
 	"github.com/warik/go-dialer/ami"
+	"github.com/warik/go-dialer/calls"
 	"github.com/warik/go-dialer/conf"
 	"github.com/warik/go-dialer/db"
+	"github.com/warik/go-dialer/events"
+	"github.com/warik/go-dialer/logger"
 	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/presence"
 	"github.com/warik/go-dialer/util"
 )
 
+const SPY_CALLER_ID_PREFIX = "spy:"
+
+// Time when dialer was started, for uptime in stats
+var startTime = time.Now()
+
+// Options of ChanSpy for each spy mode
+var SPY_MODES = map[string]string{
+	"":        "",
+	"listen":  "",
+	"whisper": "w",
+	"barge":   "B",
+	"dtmf":    "d",
+}
+
+// newParams returns new value of the same type as params of handler, so every
+// request is parsed into its own struct and nothing is left from previous ones
+func newParams(p interface{}) interface{} {
+	if p == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(p).Elem()).Interface()
+}
+
 type ApiHandler struct {
 	p  interface{}
 	fn func(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error)
@@ -27,18 +56,24 @@ func (ah ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var resp model.Response
 	var err error
 
-	err = withStructParams(ah.p, r)
+	p := newParams(ah.p)
+	// Params are not signed if authentication of route group is not configured
+	if routeAuth(r).Type == "" {
+		err = withStructParams(p, r)
+	} else {
+		err = withInputParams(p, r)
+	}
 	if err != nil {
 		glog.Errorln(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if resp, err = ah.fn(ah.p, w, r); err != nil {
+	if resp, err = ah.fn(p, w, r); err != nil {
 		glog.Errorln(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	} else {
-		glog.Info("<<< PORTAL RESPONSE", resp)
+		glog.Info("<<< PORTAL RESPONSE", conf.Redact(resp))
 		fmt.Fprint(w, resp)
 	}
 }
@@ -49,21 +84,16 @@ type AmiHandler struct {
 }
 
 func (ah AmiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if ah.p != nil {
-		var err error
-		if *signedInput {
-			err = withSignedParams(ah.p, r)
-		} else {
-			err = withStructParams(ah.p, r)
-		}
-		if err != nil {
+	p := newParams(ah.p)
+	if p != nil {
+		if err := withInputParams(p, r); err != nil {
 			glog.Errorln(err)
 			fmt.Fprint(w, model.Response{"status": "error", "error": err.Error()})
 			return
 		}
 	}
 
-	resp, err, dataKey := ah.fn(ah.p, w, r)
+	resp, err, dataKey := ah.fn(p, w, r)
 	if err != nil {
 		glog.Errorln(err)
 		fmt.Fprint(w, model.Response{"status": "error", "error": err.Error()})
@@ -72,7 +102,7 @@ func (ah AmiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r, ok := resp["Response"]; ok && r == "Follows" {
 		glog.Infoln("<<< RESPONSE...")
 	} else {
-		glog.Infoln("<<< RESPONSE", resp)
+		glog.Infoln("<<< RESPONSE", conf.Redact(resp))
 	}
 
 	status := strings.ToLower(resp["Response"])
@@ -87,16 +117,101 @@ func (ah AmiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, model.Response{"status": status, "response": response})
 }
 
+// AmiJSONHandler is the same as AmiHandler, but its function builds structured
+// response by itself instead of picking one key of asterisk response
+type AmiJSONHandler struct {
+	p  interface{}
+	fn func(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error)
+}
+
+func (ah AmiJSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := newParams(ah.p)
+	if p != nil {
+		if err := withInputParams(p, r); err != nil {
+			glog.Errorln(err)
+			fmt.Fprint(w, model.Response{"status": "error", "error": err.Error()})
+			return
+		}
+	}
+
+	resp, err := ah.fn(p, w, r)
+	if err != nil {
+		glog.Errorln(err)
+		fmt.Fprint(w, model.Response{"status": "error", "error": err.Error()})
+		return
+	}
+	if _, ok := resp["status"]; !ok {
+		resp["status"] = "success"
+	}
+	glog.Infoln("<<< RESPONSE", conf.Redact(resp))
+	fmt.Fprint(w, resp)
+}
+
+// actionResult converts asterisk response on action into structured response
+func actionResult(resp gami.Message, err error) (model.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp["Response"] != "Success" {
+		return nil, errors.New(resp["Message"])
+	}
+	return model.Response{"message": resp["Message"]}, nil
+}
+
+func withInputParams(i interface{}, r *http.Request) error {
+	if isSignedInput(r) {
+		return withSignedParams(i, r)
+	}
+	return withStructParams(i, r)
+}
+
+// isSignedInput tells if input params of request are signed by secret of agency
+func isSignedInput(r *http.Request) bool {
+	switch routeAuth(r).Type {
+	case conf.AUTH_HMAC:
+		return true
+	case "":
+		return *signedInput
+	}
+	return false
+}
+
+// signedCountry returns country which signed request is scoped to. It is the country
+// whose secret verified the signature, country in params may only repeat it. Requests
+// authenticated without signature are trusted with any country from params
+func signedCountry(r *http.Request, country string) (string, error) {
+	if !isSignedInput(r) {
+		return country, nil
+	}
+	signedData := new(model.SignedInputData)
+	if err := model.GetStructFromParams(r, signedData); err != nil {
+		return "", err
+	}
+	if signedData.Country == "" {
+		return "", errors.New("Country of signed request is not provided")
+	}
+	if country != "" && country != signedData.Country {
+		return "", fmt.Errorf("Country %s is not allowed for request signed by %s",
+			country, signedData.Country)
+	}
+	return signedData.Country, nil
+}
+
 func withSignedParams(i interface{}, r *http.Request) error {
 	signedData := new(model.SignedInputData)
 	if err := model.GetStructFromParams(r, signedData); err != nil {
 		return err
 	}
-	glog.Infoln("<<< SIGNED PARAMS", signedData)
-	if err := util.UnsignData(i, (*signedData)); err != nil {
+	glog.Infoln("<<< SIGNED PARAMS", conf.Redact(signedData))
+	unsign := util.UnsignData
+	if routeAuth(r).Type == conf.AUTH_HMAC {
+		// Signature and stamp were already checked by Authenticate
+		unsign = util.DecodeSignedData
+	}
+	if err := unsign(i, (*signedData)); err != nil {
 		return err
 	}
-	glog.Infoln("<<< INPUT PARAMS", i)
+	glog.Infoln("<<< INPUT PARAMS", conf.Redact(i))
 	return nil
 }
 
@@ -104,18 +219,186 @@ func withStructParams(i interface{}, r *http.Request) error {
 	if err := model.GetStructFromParams(r, i); err != nil {
 		return err
 	}
-	glog.Infoln("<<< INPUT PARAMS", i)
+	glog.Infoln("<<< INPUT PARAMS", conf.Redact(i))
 	return nil
 }
 
+// ReloadConfig reloads configuration file. Configuration is global, so signature of
+// one of agencies is not enough, request should be authenticated by token or client
+// certificate of self routes. Otherwise configuration is reloaded only by SIGHUP
+func ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if authType := routeAuth(r).Type; authType != conf.AUTH_TOKEN && authType != conf.AUTH_MTLS {
+		glog.Errorln("Configuration reload is not allowed with auth", authType)
+		http.Error(w, "Configuration reload requires token or mtls auth of self routes",
+			http.StatusForbidden)
+		return
+	}
+	if err := conf.Reload(); err != nil {
+		glog.Errorln("Cannot reload configuration", err)
+		fmt.Fprint(w, model.Response{"status": "error", "error": err.Error()})
+		return
+	}
+	fmt.Fprint(w, model.Response{"status": "success", "message": "Configuration is reloaded"})
+}
+
+// AuditLog returns the latest audit records of api calls
+func AuditLog(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	query := (*p.(*model.AuditQuery))
+	var from, to string
+	for _, t := range []struct {
+		value string
+		dest  *string
+	}{{query.From, &from}, {query.To, &to}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return nil, err
+		}
+		*t.dest = parsed.UTC().Format(util.TIME_FORMAT)
+	}
+	if query.Limit <= 0 || query.Limit > conf.MAX_AUDIT_RECORDS {
+		query.Limit = conf.MAX_AUDIT_RECORDS
+	}
+	records, err := db.GetDB().SelectAuditRecords(query.Route, query.Country, from, to, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return model.Response{"records": records}, nil
+}
+
+func LogLevels(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	return model.Response{"levels": logger.Levels()}, nil
+}
+
+// SetLogLevel changes level of logs of the component until restart, empty level
+// returns level from configuration
+func SetLogLevel(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	logLevel := (*p.(*model.LogLevel))
+	if logLevel.Component == "" {
+		return nil, errors.New("Component is required")
+	}
+	if err := logger.SetLevel(logLevel.Component, logLevel.Level); err != nil {
+		return nil, err
+	}
+	return model.Response{"component": logLevel.Component, "level": logger.GetLevel(logLevel.Component)}, nil
+}
+
 func Stats(w http.ResponseWriter, r *http.Request) {
-	page := `
-		<h1>{{.Name}} dialer stats</h1>
-		<b>DB CDR</b>: {{.DBCount}}
-	`
-	t, _ := template.New("stats").Parse(page)
-	stats := model.DialerStats{conf.GetConf().Name, db.GetDB().GetCdrCount()}
-	t.Execute(w, stats)
+	stats := model.DialerStats{
+		Name:             conf.GetConf().Name,
+		Uptime:           int(time.Since(startTime).Seconds()),
+		PhoneCallBacklog: db.GetDB().GetPhoneCallCount(),
+		LastPortalSends:  util.LastPortalSends(),
+		InnerNumbers:     util.GetNumbersStats(),
+	}
+	stats.Ami.Connected, stats.Ami.Reconnects = ami.Connected()
+
+	var err error
+	if stats.CdrBacklog, err = db.GetDB().SelectCdrBacklog(); err != nil {
+		glog.Errorln(err)
+	}
+	if oldest := db.GetDB().GetOldestCdrTime(); oldest != "" {
+		if t, err := util.ParseTime(oldest); err == nil {
+			stats.OldestPendingAge = int(time.Since(t).Seconds())
+		}
+	}
+	if stats.Ami.Connected {
+		if stats.Queues, err = ami.QueueSummary(""); err != nil {
+			stats.QueuesError = err.Error()
+		}
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// Dashboard renders stats for operators, they are reloaded from /stats
+func Dashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, DASHBOARD_PAGE, conf.STATS_REFRESH_INTERVAL/time.Millisecond)
+}
+
+// Events streams call lifecycle events as server-sent events. Subscriber may
+// narrow the stream by inner number, signed subscriber gets only events of its country
+func Events(w http.ResponseWriter, r *http.Request) {
+	filter := new(model.EventsFilter)
+	err := withInputParams(filter, r)
+	if err == nil {
+		filter.Country, err = signedCountry(r, filter.Country)
+	}
+	if err != nil {
+		glog.Errorln(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	subscription := events.Subscribe(*filter)
+	defer events.Unsubscribe(subscription)
+	keepAlive := time.NewTicker(conf.EVENTS_KEEPALIVE_INTERVAL)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// ActiveCalls returns active calls, signed request gets only calls of its country
+func ActiveCalls(w http.ResponseWriter, r *http.Request) {
+	country, err := filterCountry(r)
+	if err != nil {
+		glog.Errorln(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(w, model.Response{"calls": calls.Active(country)})
+}
+
+// filterCountry returns country from params of request, scoped by its signature
+func filterCountry(r *http.Request) (string, error) {
+	filter := new(model.CountryFilter)
+	if err := withInputParams(filter, r); err != nil {
+		return "", err
+	}
+	return signedCountry(r, filter.Country)
+}
+
+// Presence returns presence of inner numbers, signed request gets only numbers of its country
+func Presence(w http.ResponseWriter, r *http.Request) {
+	country, err := filterCountry(r)
+	if err != nil {
+		glog.Errorln(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(w, model.Response{"presence": presence.All(country)})
 }
 
 func CdrCount(w http.ResponseWriter, r *http.Request) {
@@ -157,6 +440,15 @@ func ManagerCallAfterHours(p interface{}, w http.ResponseWriter,
 	return model.Response{"status": resp}, err
 }
 
+// IsOpen tells asterisk if the country works right now by its calendar
+func IsOpen(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	phoneCall := (*p.(*model.PhoneCall))
+	if _, ok := conf.GetConf().Agencies[phoneCall.Country]; !ok {
+		return nil, fmt.Errorf("Unknown country - %s", phoneCall.Country)
+	}
+	return model.Response{"is_open": conf.GetConf().IsOpen(phoneCall.Country, time.Now())}, nil
+}
+
 func ShowCallingReview(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
 	phoneCall := (*p.(*model.PhoneCall))
 	resp, err := util.ShowReviewPopup(
@@ -220,15 +512,277 @@ func QueueAdd(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Messa
 	return resp, err, "StatusKey"
 }
 
+func QueuePause(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	qp := (*p.(*model.QueuePause))
+	return actionResult(ami.PauseInQueue(qp.Queue, qp.InnerNumber, qp.Paused, qp.Reason))
+}
+
+func QueuePenalty(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	qp := (*p.(*model.QueuePenalty))
+	return actionResult(ami.SetQueuePenalty(qp.Queue, qp.InnerNumber, qp.Penalty))
+}
+
+func QueueMembers(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	qc := (*p.(*model.QueueContainer))
+	members, err := ami.QueueMembers(qc.Queue)
+	if err != nil {
+		return nil, err
+	}
+	queues := map[string][]model.QueueMember{}
+	for _, member := range members {
+		queues[member.Queue] = append(queues[member.Queue], member)
+	}
+	return model.Response{"queues": queues}, nil
+}
+
+func QueueSummary(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	qc := (*p.(*model.QueueContainer))
+	summaries, err := ami.QueueSummary(qc.Queue)
+	if err != nil {
+		return nil, err
+	}
+	return model.Response{"queues": summaries}, nil
+}
+
 func QueueStatus(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Message, error, string) {
 	qc := (*p.(*model.QueueContainer))
 	resp, err := ami.QueueStatus(qc.Queue, qc.InnerNumber)
 	return resp, err, "StatusKey"
 }
 
+func CallHangup(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	cc := (*p.(*model.CallControl))
+	channel, err := findChannel(cc, r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := actionResult(ami.Hangup(channel.Channel))
+	return withChannel(channel, resp, err)
+}
+
+// CallTransfer makes blind transfer by redirecting opponent of inner number, or
+// attended one, in which inner number talks with transfer target first
+func CallTransfer(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	cc := (*p.(*model.CallControl))
+	if cc.Exten == "" {
+		return nil, errors.New("Exten for transfer is not provided")
+	}
+	channel, err := findChannel(cc, r)
+	if err != nil {
+		return nil, err
+	}
+	if cc.Attended {
+		resp, err := actionResult(ami.Atxfer(channel.Channel, channel.InnerNumber, cc.Exten))
+		return withChannel(channel, resp, err)
+	}
+	peer, err := calls.Peer(channel.UniqueId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := actionResult(ami.Redirect(peer.Channel, channel.InnerNumber, cc.Exten))
+	return withChannel(peer, resp, err)
+}
+
+func CallPark(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	cc := (*p.(*model.CallControl))
+	channel, err := findChannel(cc, r)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := calls.Peer(channel.UniqueId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := actionResult(ami.Park(peer.Channel, channel.Channel))
+	return withChannel(peer, resp, err)
+}
+
+func CallRecordStart(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	cc := (*p.(*model.CallControl))
+	channel, err := findChannel(cc, r)
+	if err != nil {
+		return nil, err
+	}
+	fileName := util.GetPhoneCallFileName(conf.GetConf().Name, channel.UniqueId, "wav")
+	fullFileName := fmt.Sprintf("%s/%s", conf.GetConf().FolderForCalls, fileName)
+	resp, err := actionResult(ami.SendMixMonitor(channel.Channel, fullFileName))
+	if err != nil {
+		return nil, err
+	}
+	resp["file"] = fileName
+	return withChannel(channel, resp, nil)
+}
+
+func CallRecordStop(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	cc := (*p.(*model.CallControl))
+	channel, err := findChannel(cc, r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := actionResult(ami.StopMixMonitor(channel.Channel))
+	return withChannel(channel, resp, err)
+}
+
+// CallHold holds opponent of inner number, audio of its channel is muted in both
+// directions until the call is unheld
+func CallHold(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	return holdCall(p, r, true)
+}
+
+func CallUnhold(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	return holdCall(p, r, false)
+}
+
+func holdCall(p interface{}, r *http.Request, hold bool) (model.Response, error) {
+	cc := (*p.(*model.CallControl))
+	channel, err := findChannel(cc, r)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := calls.Peer(channel.UniqueId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := actionResult(ami.MuteAudio(peer.Channel, hold))
+	return withChannel(peer, resp, err)
+}
+
+// findChannel returns active channel of call control request. Signed request may
+// control only calls of inner numbers of its country
+func findChannel(cc model.CallControl, r *http.Request) (model.CallParticipant, error) {
+	channel, err := calls.Find(cc.UniqueId, cc.InnerNumber)
+	if err != nil {
+		return channel, err
+	}
+	if !isSignedInput(r) {
+		return channel, nil
+	}
+	country, err := signedCountry(r, "")
+	if err != nil {
+		return model.CallParticipant{}, err
+	}
+	for _, c := range util.GetCountriesByNumber(channel.InnerNumber) {
+		if c == country {
+			return channel, nil
+		}
+	}
+	return model.CallParticipant{}, fmt.Errorf("Channel %s does not belong to %s",
+		channel.Channel, country)
+}
+
+// withChannel adds channel on which action was made to its result
+func withChannel(channel model.CallParticipant, resp model.Response, err error) (
+	model.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	resp["channel"] = channel.Channel
+	resp["unique_id"] = channel.UniqueId
+	return resp, nil
+}
+
+func AddCampaign(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	campaign := (*p.(*model.Campaign))
+	if _, ok := conf.GetConf().Agencies[campaign.Country]; !ok {
+		return nil, fmt.Errorf("Unknown country - %s", campaign.Country)
+	}
+	if campaign.HoursFrom == "" {
+		campaign.HoursFrom = conf.CAMPAIGN_HOURS_FROM
+	}
+	if campaign.HoursTo == "" {
+		campaign.HoursTo = conf.CAMPAIGN_HOURS_TO
+	}
+	if _, err := conf.IsInHours(time.Now(), campaign.HoursFrom, campaign.HoursTo); err != nil {
+		return nil, err
+	}
+	if campaign.MaxConcurrent <= 0 {
+		campaign.MaxConcurrent = 1
+	}
+	if campaign.MaxAttempts <= 0 {
+		campaign.MaxAttempts = 1
+	}
+	if campaign.RetryInterval <= 0 {
+		campaign.RetryInterval = conf.CAMPAIGN_RETRY_INTERVAL
+	}
+	// Without queue numbers are dialed by callback queue of the country
+	if campaign.Queue != "" {
+		summaries, err := ami.QueueSummary(campaign.Queue)
+		if err != nil {
+			return nil, err
+		} else if len(summaries) == 0 {
+			return nil, fmt.Errorf("Unknown queue - %s", campaign.Queue)
+		}
+	}
+
+	numbers, err := util.ParseNumbers(campaign.Numbers, campaign.Csv)
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) == 0 {
+		return nil, errors.New("Campaign has no numbers")
+	}
+
+	id, err := db.GetDB().AddCampaign(db.Campaign{
+		Name:          campaign.Name,
+		Country:       campaign.Country,
+		Queue:         campaign.Queue,
+		HoursFrom:     campaign.HoursFrom,
+		HoursTo:       campaign.HoursTo,
+		MaxConcurrent: campaign.MaxConcurrent,
+		MaxAttempts:   campaign.MaxAttempts,
+		RetryInterval: campaign.RetryInterval,
+	}, numbers)
+	if err != nil {
+		return nil, err
+	}
+	return model.Response{"id": id, "numbers": len(numbers)}, nil
+}
+
+func CampaignStatus(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	campaignId := (*p.(*model.CampaignId))
+	campaign, numbers, err := db.GetDB().GetCampaign(campaignId.Id)
+	if err != nil {
+		return nil, err
+	}
+	return model.Response{"campaign": campaign, "numbers": numbers}, nil
+}
+
+// PlaceSpy calls supervisor and starts spying on the call of target inner number,
+// or on the active call by its id. Spy session lasts until supervisor hangs up
 func PlaceSpy(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Message, error, string) {
-	call := (*p.(*model.Call))
-	resp, err := ami.Spy(call)
+	spy := (*p.(*model.Spy))
+	options, ok := SPY_MODES[spy.Mode]
+	if !ok {
+		return nil, fmt.Errorf("Unknown spy mode - %s", spy.Mode), ""
+	}
+
+	var target, targetName string
+	if spy.CallId != "" {
+		channel, err := calls.Find(spy.CallId, "")
+		if err != nil {
+			return nil, err, ""
+		}
+		target, targetName = channel.Channel, channel.Channel
+	} else if spy.Exten != "" {
+		target, targetName = ami.SpyTarget(spy.Exten), spy.Exten
+	} else {
+		return nil, errors.New("Spy target is not provided"), ""
+	}
+
+	mode := spy.Mode
+	if mode == "" {
+		mode = "listen"
+	}
+	id, err := db.GetDB().AddSpySession(spy.Inline, targetName, mode)
+	if err != nil {
+		return nil, err, ""
+	}
+	// Session id in caller id lets us find the session when supervisor hangs up
+	callerId := fmt.Sprintf("%s%d <%s>", SPY_CALLER_ID_PREFIX, id, targetName)
+	resp, err := ami.Spy(spy.Inline, target, options, callerId)
+	if err != nil || resp["Response"] == "Error" {
+		db.GetDB().FinishSpySession(int(id))
+	}
 	return resp, err, "Message"
 }
 
@@ -237,16 +791,47 @@ func ShowInuse(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Mess
 	return resp, err, "CmdData"
 }
 
+// PlaceCall calls inner number and then exten. Country of signed request is
+// remembered, so states of the call are reported to portal which placed it
 func PlaceCall(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Message, error, string) {
-	resp, err := ami.Call(*p.(*model.Call))
+	call := (*p.(*model.Call))
+	if country, err := signedCountry(r, ""); err == nil && country != "" && call.UniqueId != "" {
+		crmCallsCountries.Put(call.UniqueId, country)
+	}
+	resp, err := ami.Call(call)
+	if err != nil || resp["Response"] == "Error" {
+		crmCallsCountries.Remove(call.UniqueId)
+	}
 	return resp, err, "Message"
 }
 
+// PlaceCallInQueue saves callback request and dials it right away. If it cannot be
+// dialed now, or nobody answers, it is retried later by CallBackDialer
 func PlaceCallInQueue(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Message, error, string) {
-	resp, err := ami.CallInQueue(*p.(*model.CallInQueue))
+	call := (*p.(*model.CallInQueue))
+	if _, ok := conf.GetConf().Agencies[call.Country]; !ok {
+		return nil, fmt.Errorf("Unknown country - %s", call.Country), ""
+	}
+	callback, created, err := db.GetDB().AddCallback(call.PhoneNumber, call.Country, 0)
+	if err != nil {
+		return nil, err, ""
+	}
+	if !created {
+		return gami.Message{"Message": "Callback is already requested"}, nil, "Message"
+	}
+	resp, err := DialCallBack(callback)
 	return resp, err, "Message"
 }
 
+func CallInQueueStatus(p interface{}, w http.ResponseWriter, r *http.Request) (model.Response, error) {
+	call := (*p.(*model.CallInQueue))
+	callbacks, err := db.GetDB().SelectCallbacks(call.PhoneNumber, conf.MAX_CALLBACKS_NUMBER)
+	if err != nil {
+		return nil, err
+	}
+	return model.Response{"callbacks": callbacks}, nil
+}
+
 func PingAsterisk(p interface{}, w http.ResponseWriter, r *http.Request) (gami.Message, error, string) {
 	resp, err := ami.Ping()
 	return resp, err, "Ping"
@@ -0,0 +1,198 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/warik/go-dialer/conf"
+)
+
+const (
+	DEBUG   = "debug"
+	INFO    = "info"
+	WARNING = "warning"
+	ERROR   = "error"
+
+	// Level of components which have no level of their own
+	DEFAULT_COMPONENT = "default"
+)
+
+var (
+	LEVELS = map[string]int{DEBUG: 0, INFO: 1, WARNING: 2, ERROR: 3}
+	// Values of these fields are never written to logs
+	SENSITIVE_FIELDS = map[string]struct{}{
+		"secret": {}, "password": {}, "data": {}, "signature": {}, "token": {},
+	}
+
+	output = struct {
+		sync.Mutex
+		w io.Writer
+	}{w: os.Stderr}
+	// Levels set at runtime, they take precedence over levels from configuration
+	levels = struct {
+		sync.RWMutex
+		m map[string]string
+	}{m: map[string]string{}}
+)
+
+// Fields of log entry, like unique_id, inner_number and country of the call
+type Fields map[string]interface{}
+
+// Logger writes entries of one component as json lines
+type Logger struct {
+	component string
+	fields    Fields
+}
+
+func New(component string) Logger {
+	return Logger{component: component}
+}
+
+// With returns logger which adds fields to every entry
+func (l Logger) With(fields Fields) Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return Logger{component: l.component, fields: merged}
+}
+
+func (l Logger) Debug(msg string, fields ...Fields) {
+	l.log(DEBUG, msg, fields)
+}
+
+func (l Logger) Info(msg string, fields ...Fields) {
+	l.log(INFO, msg, fields)
+}
+
+func (l Logger) Warning(msg string, fields ...Fields) {
+	l.log(WARNING, msg, fields)
+}
+
+func (l Logger) Error(msg string, fields ...Fields) {
+	l.log(ERROR, msg, fields)
+}
+
+func (l Logger) log(level, msg string, fields []Fields) {
+	if !Enabled(l.component, level) {
+		return
+	}
+	entry := map[string]interface{}{}
+	for _, f := range append([]Fields{l.fields}, fields...) {
+		for k, v := range f {
+			entry[k] = redact(k, v)
+		}
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["component"] = l.component
+	entry["msg"] = conf.Redact(msg)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"error","component":%q,"msg":"Cannot encode log entry"}`,
+			l.component))
+	}
+	output.Lock()
+	defer output.Unlock()
+	output.w.Write(append(line, '\n'))
+}
+
+// redact hides sensitive fields and secrets of configuration in values. Maps, slices
+// and structs are redacted recursively and stay structured in json of the entry
+func redact(key string, value interface{}) interface{} {
+	if _, ok := SENSITIVE_FIELDS[strings.ToLower(key)]; ok {
+		return conf.REDACTED
+	}
+	switch v := value.(type) {
+	case nil, bool, int, int64, float64, time.Time, time.Duration:
+		return value
+	case string:
+		return conf.Redact(v)
+	case error:
+		return conf.Redact(v.Error())
+	case map[string]interface{}:
+		redacted := map[string]interface{}{}
+		for k, item := range v {
+			redacted[k] = redact(k, item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := []interface{}{}
+		for _, item := range v {
+			redacted = append(redacted, redact(key, item))
+		}
+		return redacted
+	}
+	// Other values are redacted in their json form, which has only types above
+	data, err := json.Marshal(value)
+	if err != nil {
+		return conf.Redact(value)
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return conf.Redact(value)
+	}
+	return redact(key, decoded)
+}
+
+// Enabled tells if entries of the level are written for the component
+func Enabled(component, level string) bool {
+	return LEVELS[level] >= LEVELS[GetLevel(component)]
+}
+
+// GetLevel returns level of the component, set at runtime or in configuration
+func GetLevel(component string) string {
+	levels.RLock()
+	level, ok := levels.m[component]
+	levels.RUnlock()
+	if ok {
+		return level
+	}
+	configured := conf.GetConf().LogLevels
+	if level, ok := configured[component]; ok {
+		return level
+	}
+	if level, ok := configured[DEFAULT_COMPONENT]; ok {
+		return level
+	}
+	return INFO
+}
+
+// SetLevel changes level of the component until restart, empty level
+// returns level from configuration
+func SetLevel(component, level string) error {
+	if _, ok := LEVELS[level]; !ok && level != "" {
+		return fmt.Errorf("Unknown log level - %s", level)
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if level == "" {
+		delete(levels.m, component)
+	} else {
+		levels.m[component] = level
+	}
+	return nil
+}
+
+// Levels returns levels which were set at runtime or in configuration
+func Levels() map[string]string {
+	result := map[string]string{}
+	for component, level := range conf.GetConf().LogLevels {
+		result[component] = level
+	}
+	levels.RLock()
+	defer levels.RUnlock()
+	for component, level := range levels.m {
+		result[component] = level
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/model"
+)
+
+func TestRedact(t *testing.T) {
+	conf.SetConf(&conf.Configuration{AMIPassword: "amisecret"})
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  interface{}
+	}{
+		{"sensitive field", "secret", "abc", conf.REDACTED},
+		{"scalar", "count", 3, 3},
+		{"secret of configuration in string", "msg", "password is amisecret", "password is " + conf.REDACTED},
+		{"error", "error", errors.New("bad amisecret"), "bad " + conf.REDACTED},
+		{
+			"map stays structured",
+			"event",
+			map[string]string{"Event": "Hangup", "Secret": "abc", "Data": "amisecret"},
+			map[string]interface{}{"Event": "Hangup", "Secret": conf.REDACTED, "Data": conf.REDACTED},
+		},
+		{
+			"struct and slice stay structured",
+			"presence",
+			[]model.Presence{{InnerNumber: "101", State: "idle"}},
+			[]interface{}{map[string]interface{}{"inner_number": "101", "state": "idle",
+				"since": "0001-01-01T00:00:00Z"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := redact(tt.key, tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
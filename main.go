@@ -2,11 +2,21 @@ package main
 
 import (
 	_ "crypto/sha512"
-	_ "net/http/pprof"
 
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,30 +24,54 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zenazn/goji"
+	"github.com/zenazn/goji/bind"
+	"github.com/zenazn/goji/graceful"
+	"github.com/zenazn/goji/web"
 
-	"github.com/warik/gami"
 	"github.com/warik/go-dialer/ami"
+	"github.com/warik/go-dialer/calls"
 	"github.com/warik/go-dialer/conf"
 	"github.com/warik/go-dialer/db"
+	"github.com/warik/go-dialer/events"
+	"github.com/warik/go-dialer/metrics"
 	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/presence"
+	"github.com/warik/go-dialer/s3"
 	"github.com/warik/go-dialer/util"
 )
 
 var (
-	signedInput    = flag.Bool("signed_input", true, "Set true to check input params for correct signature")
-	savePhoneCalls = flag.Bool("save_calls", false, "Set true to save phone calls")
-	showPopups     = flag.Bool("show_popups", false, "Set true to show popups on portal before and after call")
-	sendCalls      = flag.Bool("send_calls", false, "Set true to convert and send phone calls")
-	manageQueues   = flag.Bool("manage_queues", false, "Set true to enable asterisk queue management")
+	signedInput        = flag.Bool("signed_input", true, "Set true to check signature of input params of routes without configured Auth")
+	savePhoneCalls     = flag.Bool("save_calls", false, "Set true to save phone calls")
+	showPopups         = flag.Bool("show_popups", false, "Set true to show popups on portal before and after call")
+	sendCalls          = flag.Bool("send_calls", false, "Set true to convert and send phone calls")
+	manageQueues       = flag.Bool("manage_queues", false, "Set true to enable asterisk queue management")
+	manageQueuesDryRun = flag.Bool("manage_queues_dry_run", false, "Set true to only log queue management changes")
+	pushPresence       = flag.Bool("push_presence", false, "Set true to send managers presence changes to portal")
+	dialCampaigns      = flag.Bool("dial_campaigns", false, "Set true to dial numbers of outbound campaigns")
+	checkConfig        = flag.Bool("check-config", false, "Check configuration file and exit")
 )
 
 func init() {
-	flag.Parse()
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
 func main() {
+	flag.Parse()
+	conf.Require(conf.Requirements{
+		CallsFolder: *savePhoneCalls || *sendCalls,
+		Storage:     *sendCalls,
+	})
+	if *checkConfig {
+		os.Exit(checkConf())
+	}
+	// Configuration is checked before first use, since GetConf panics if it cannot be read
+	if err := conf.Check(); err != nil {
+		glog.Exitln("Bad configuration:", err)
+	}
+
 	wg := sync.WaitGroup{}
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
@@ -45,6 +79,20 @@ func main() {
 	NumbersLoader(ctx, &wg, numbersChan, time.NewTicker(conf.NUMBERS_LOAD_INTERVAL))
 	util.LoadInnerNumbers(numbersChan)
 
+	// Configuration is reloaded on SIGHUP or by /config/reload, components which keep
+	// state built from it are renewed after reload
+	conf.OnChange(ami.ConfChanged)
+	conf.OnChange(s3.ConfChanged)
+	conf.OnChange(func(old, new *conf.Configuration) {
+		if reflect.DeepEqual(old.Agencies, new.Agencies) {
+			return
+		}
+		util.ForgetCountries(new.Agencies)
+		go util.LoadInnerNumbers(numbersChan)
+	})
+	ReloadOnSignal(ctx, &wg)
+	AuditCleaner(ctx, &wg, time.NewTicker(conf.AUDIT_CLEAN_INTERVAL))
+
 	mChan := make(chan db.CDR, conf.MAX_CDR_NUMBER*2)
 	// CdrReader reads cdrs from db once in a while and sends them to CdrSender
 	CdrReader(ctx, &wg, mChan, time.NewTicker(conf.CDR_READ_INTERVAL))
@@ -67,54 +115,85 @@ func main() {
 		}
 	}
 
-	// Old way to handle queues
-	// if *manageQueues {
-	// queueTransport := make(chan chan gami.Message)
-	// // QueueMember handler gathers information about current queue occupations
-	// // those events start to come after QueueStatus action will be sent
-	// queueStatusChan := make(chan gami.Message, 1000)
-	// qmh := func(m gami.Message) {
-	// 	if strings.HasPrefix(m["Name"], "Local") {
-	// 		queueStatusChan <- m
-	// 	}
-	// }
-	// ami.GetAMI().RegisterHandler("QueueMember", &qmh)
-
-	// // QueueStatusComplete tells us that queueMember events were sent, so we can send this
-	// // info to queueManager and use for determination of managers states in queues
-	// qsch := func(m gami.Message) {
-	// 	queueTransport <- queueStatusChan
-	// }
-	// ami.GetAMI().RegisterHandler("QueueStatusComplete", &qsch)
-	//
-	// // QueueManager does some heavy stuff on managing asterisk queues according to managers
-	// // status and sends fresh data to portal, so the portal works only with local data
-	// finishChannels = append(finishChannels, make(chan struct{}))
-	// wg.Add(1)
-	// go QueueManager(&wg, queueTransport, finishChannels[len(finishChannels)-1],
-	// 	time.NewTicker(conf.QUEUE_RENEW_INTERVAL))
-	// }
+	if *manageQueues {
+		// QueueManager does some heavy stuff on managing asterisk queues according to managers
+		// status and sends fresh data to portal, so the portal works only with local data
+		QueueManager(ctx, &wg, amiQueues{}, *manageQueuesDryRun,
+			time.NewTicker(conf.QUEUE_RENEW_INTERVAL))
+	}
+
+	if *dialCampaigns {
+		// CampaignDialer dials campaign numbers, CampaignEventHandler saves results
+		// of the calls
+		CampaignDialer(ctx, &wg, time.NewTicker(conf.CAMPAIGN_DIAL_INTERVAL))
+		for _, event := range CAMPAIGN_EVENTS {
+			ami.RegisterHandler(event, CampaignEventHandler)
+		}
+	}
+
+	// CallBackDialer retries callback requests, CallBackEventHandler saves results
+	// of their calls
+	CallBackDialer(ctx, &wg, time.NewTicker(conf.CALLBACK_DIAL_INTERVAL))
+	for _, event := range CALLBACK_EVENTS {
+		ami.RegisterHandler(event, CallBackEventHandler)
+	}
 
 	if *savePhoneCalls || *showPopups {
 		// BridgeEventHandler initiates MixMonitor for call recording
 		// and shows popup for manager in portal
-		beh := BridgeEventHandler
-		ami.GetAMI().RegisterHandler("BridgeEnter", &beh)
+		ami.RegisterHandler("BridgeEnter", BridgeEventHandler)
 	}
 
-	// Handles response from queue status action
-	qmh := func(m gami.Message) {
-		ami.QueueState.Put(strings.Split(m["Name"], "/")[1], m["Status"])
-	}
-	ami.GetAMI().RegisterHandler("QueueMember", &qmh)
-
 	// CdrEventHandler reads cdrs, processes them and stores in db for further
 	// sending to corresponding portals
-	ceh := CdrEventHandler
-	ami.GetAMI().RegisterHandler("Cdr", &ceh)
+	ami.RegisterHandler("Cdr", CdrEventHandler)
+
+	// Call lifecycle events are streamed to subscribers of /events
+	for _, event := range events.AMI_EVENTS {
+		ami.RegisterHandler(event, events.AMIEventHandler)
+	}
+	graceful.PreHook(events.Close)
+
+	// Registry of active calls is fed by channel events and seeded with
+	// channels which were already active before start
+	for _, event := range calls.AMI_EVENTS {
+		ami.RegisterHandler(event, calls.AMIEventHandler)
+	}
+	seedCalls := func() {
+		if err := calls.Seed(); err != nil {
+			glog.Errorln("Cannot seed active calls", err)
+		}
+	}
+	go seedCalls()
+	ami.OnReconnect(seedCalls)
+
+	// CallStatusEventHandler reports states of calls placed from CRM
+	for _, event := range CALL_STATUS_EVENTS {
+		ami.RegisterHandler(event, CallStatusEventHandler)
+	}
+
+	// SpyEventHandler finishes spy sessions when supervisor hangs up
+	// or cannot be called
+	for _, event := range SPY_EVENTS {
+		ami.RegisterHandler(event, SpyEventHandler)
+	}
+
+	// PresenceEventHandler tracks device states of inner numbers
+	// and reports their changes to portal
+	for _, event := range presence.AMI_EVENTS {
+		ami.RegisterHandler(event, PresenceEventHandler)
+	}
 
 	initRoutes()
-	goji.Serve()
+	tlsConfig, err := conf.GetConf().GetTLSConfig()
+	if err != nil {
+		glog.Exitln(err)
+	}
+	listener := bind.Default()
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	serve(listener)
 
 	// We need to switch ami first of all to avoid it sending any data
 	ami.GetAMI().Logoff()
@@ -125,38 +204,104 @@ func main() {
 	glog.Flush()
 }
 
+// serve runs routes of goji until dialer is stopped. Unlike goji.Serve it does not
+// serve http.DefaultServeMux, so handlers which packages register there, like
+// net/http/pprof, are not reachable bypassing middlewares of route groups
+func serve(listener net.Listener) {
+	goji.DefaultMux.Compile()
+	glog.Infoln("Starting on", listener.Addr())
+	graceful.HandleSignals()
+	bind.Ready()
+	if err := graceful.Serve(listener, goji.DefaultMux); err != nil {
+		glog.Exitln(err)
+	}
+	graceful.Wait()
+}
+
+// checkConf prints all problems of configuration file and returns exit code
+func checkConf() int {
+	err := conf.Check()
+	if err == nil {
+		fmt.Println("Configuration is valid")
+		return 0
+	}
+	fmt.Println("Configuration is not valid:")
+	if errs, ok := err.(conf.ValidationError); ok {
+		for _, e := range errs {
+			fmt.Println("  -", e)
+		}
+	} else {
+		fmt.Println("  -", err)
+	}
+	return 1
+}
+
 func initRoutes() {
+	self, crm, asterisk := routeGroup(conf.ROUTES_SELF), routeGroup(conf.ROUTES_CRM),
+		routeGroup(conf.ROUTES_ASTERISK)
+
 	// API for self
-	goji.Get("/", ImUp)
-	goji.Get("/ping", AmiHandler{nil, PingAsterisk})
-	goji.Get("/check-portals", CheckPortals)
-	goji.Get("/stats", Stats)
-	goji.Get("/cdr/count", CdrCount)
-	goji.Get("/cdr/get", ApiHandler{new(model.Cdr), GetCdr})
-	goji.Post("/cdr/delete", ApiHandler{new(model.Cdr), DeleteCdr})
+	self.Get("/", ImUp)
+	self.Get("/ping", AmiHandler{nil, PingAsterisk})
+	self.Get("/check-portals", CheckPortals)
+	self.Get("/stats", Stats)
+	self.Get("/dashboard", Dashboard)
+	self.Get("/metrics", promhttp.Handler())
+	self.Post("/config/reload", ReloadConfig)
+	self.Get("/audit", AmiJSONHandler{new(model.AuditQuery), AuditLog})
+	self.Get("/log/levels", AmiJSONHandler{nil, LogLevels})
+	self.Post("/log/level", AmiJSONHandler{new(model.LogLevel), SetLogLevel})
+	self.Get("/cdr/count", CdrCount)
+	self.Get("/cdr/get", ApiHandler{new(model.Cdr), GetCdr})
+	self.Post("/cdr/delete", ApiHandler{new(model.Cdr), DeleteCdr})
+	self.Get("/events", Events)
+	self.Get("/calls/active", ActiveCalls)
+	self.Get("/presence", Presence)
+	self.Get("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	self.Get("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	self.Get("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	self.Get("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	self.Get("/debug/pprof/*", http.HandlerFunc(pprof.Index))
 
 	//API for prom
-	goji.Get("/show_inuse", AmiHandler{new(model.DummyStruct), ShowInuse})
-	goji.Post("/call", AmiHandler{new(model.Call), PlaceCall})
-	goji.Post("/call_in_queue", AmiHandler{new(model.CallInQueue), PlaceCallInQueue})
-	goji.Post("/spy", AmiHandler{new(model.Call), PlaceSpy})
-	goji.Post("/queue_add", AmiHandler{new(model.QueueContainer), QueueAdd})
-	goji.Post("/queue_remove", AmiHandler{new(model.QueueContainer), QueueRemove})
-	goji.Get("/queue_status", AmiHandler{new(model.QueueContainer), QueueStatus})
+	crm.Get("/show_inuse", AmiHandler{new(model.DummyStruct), ShowInuse})
+	crm.Post("/call", AmiHandler{new(model.Call), PlaceCall})
+	crm.Post("/call_in_queue", AmiHandler{new(model.CallInQueue), PlaceCallInQueue})
+	crm.Get("/call_in_queue/status", AmiJSONHandler{new(model.CallInQueue), CallInQueueStatus})
+	crm.Post("/spy", AmiHandler{new(model.Spy), PlaceSpy})
+	crm.Post("/call/hangup", AmiJSONHandler{new(model.CallControl), CallHangup})
+	crm.Post("/call/transfer", AmiJSONHandler{new(model.CallControl), CallTransfer})
+	crm.Post("/call/hold", AmiJSONHandler{new(model.CallControl), CallHold})
+	crm.Post("/call/unhold", AmiJSONHandler{new(model.CallControl), CallUnhold})
+	crm.Post("/call/park", AmiJSONHandler{new(model.CallControl), CallPark})
+	crm.Post("/call/record/start", AmiJSONHandler{new(model.CallControl), CallRecordStart})
+	crm.Post("/call/record/stop", AmiJSONHandler{new(model.CallControl), CallRecordStop})
+	crm.Post("/queue_add", AmiHandler{new(model.QueueContainer), QueueAdd})
+	crm.Post("/queue_remove", AmiHandler{new(model.QueueContainer), QueueRemove})
+	crm.Get("/queue_status", AmiHandler{new(model.QueueContainer), QueueStatus})
+	crm.Post("/campaign", AmiJSONHandler{new(model.Campaign), AddCampaign})
+	crm.Get("/campaign/status", AmiJSONHandler{new(model.CampaignId), CampaignStatus})
+	crm.Post("/queue_pause", AmiJSONHandler{new(model.QueuePause), QueuePause})
+	crm.Post("/queue_penalty", AmiJSONHandler{new(model.QueuePenalty), QueuePenalty})
+	crm.Get("/queue_members", AmiJSONHandler{new(model.QueueContainer), QueueMembers})
+	crm.Get("/queue_summary", AmiJSONHandler{new(model.QueueContainer), QueueSummary})
 
 	// API for asterisk
-	goji.Get("/api/manager_phone", ApiHandler{new(model.PhoneCall), ManagerPhone})
-	goji.Get("/api/manager_phone_for_company",
+	asterisk.Get("/api/manager_phone", ApiHandler{new(model.PhoneCall), ManagerPhone})
+	asterisk.Get("/api/manager_phone_for_company",
 		ApiHandler{new(model.PhoneCall), ManagerPhoneForCompany})
-	goji.Get("/api/show_calling_popup_to_manager",
+	asterisk.Get("/api/show_calling_popup_to_manager",
 		ApiHandler{new(model.PhoneCall), ShowCallingPopup})
-	goji.Get("/api/show_calling_review_popup_to_manager",
+	asterisk.Get("/api/show_calling_review_popup_to_manager",
 		ApiHandler{new(model.PhoneCall), ShowCallingReview})
-	goji.Get("/api/manager_call_after_hours",
+	asterisk.Get("/api/manager_call_after_hours",
 		ApiHandler{new(model.PhoneCall), ManagerCallAfterHours})
+	asterisk.Get("/api/is_open", ApiHandler{new(model.PhoneCall), IsOpen})
 
+	goji.Use(AllowedRemoteAddress)
 	goji.Use(JSONReponse)
-	// goji.Use(AllowedRemoteAddress)
+	goji.Use(AuditRequests)
+	goji.Use(Authenticate)
 }
 
 func JSONReponse(h http.Handler) http.Handler {
@@ -167,15 +312,209 @@ func JSONReponse(h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func AllowedRemoteAddress(h http.Handler) http.Handler {
+// AuditRequests saves audit records of mutating api calls, which are all POST requests
+func AuditRequests(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		for _, addr := range conf.GetConf().AllowedRemoteAddrs {
-			if addr == strings.Split(r.RemoteAddr, ":")[0] {
-				h.ServeHTTP(w, r)
-				return
+		if r.Method != "POST" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(recorder, r)
+
+		country, params := auditParams(r)
+		result := recorder.body.String()
+		if len(result) > conf.AUDIT_RESULT_SIZE {
+			result = result[:conf.AUDIT_RESULT_SIZE]
+		}
+		record := db.AuditRecord{
+			RemoteAddr: remoteIP(r),
+			Country:    country,
+			Route:      r.URL.Path,
+			Params:     conf.Redact(params),
+			Result:     conf.Redact(result),
+			Status:     recorder.status(),
+		}
+		if _, err := db.GetDB().AddAuditRecord(record); err != nil {
+			glog.Errorln("Cannot save audit record", err)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// auditParams returns country of signed request if signature is valid and
+// params of request, decoded from signed data
+func auditParams(r *http.Request) (string, string) {
+	signedData := new(model.SignedInputData)
+	model.GetStructFromParams(r, signedData)
+	if signedData.Data == "" {
+		params, _ := json.Marshal(r.Form)
+		return "", string(params)
+	}
+	data := map[string]interface{}{}
+	if err := util.DecodeSignedData(&data, *signedData); err != nil {
+		return "", "Bad signature"
+	}
+	params, _ := json.Marshal(data)
+	return signedData.Country, string(params)
+}
+
+// responseRecorder keeps response, so it can be saved to audit log
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.code = code
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// status returns status from json response or http status if response has none
+func (rr *responseRecorder) status() string {
+	resp := model.Response{}
+	if err := json.Unmarshal(rr.body.Bytes(), &resp); err == nil {
+		if status, ok := resp["status"].(string); ok {
+			return status
+		}
+	}
+	return strconv.Itoa(rr.code)
+}
+
+// remoteIP returns address of client. If request came from trusted proxy, it is the
+// last address in X-Forwarded-For which is not of trusted proxy
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	config := conf.GetConf()
+	if !config.IsTrustedProxy(net.ParseIP(host)) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+		host = addr
+		if !config.IsTrustedProxy(net.ParseIP(addr)) {
+			break
+		}
+	}
+	return host
+}
+
+// routeGroups keeps group of every route, requests to them are checked by rules of the group
+var routeGroups = map[string]string{}
+
+type routeGroup string
+
+func (g routeGroup) Get(pattern string, handler web.HandlerType) {
+	routeGroups[pattern] = string(g)
+	goji.Get(pattern, handler)
+}
+
+func (g routeGroup) Post(pattern string, handler web.HandlerType) {
+	routeGroups[pattern] = string(g)
+	goji.Post(pattern, handler)
+}
+
+// getRouteGroup returns group of requested route, unknown routes are in group of self api
+func getRouteGroup(r *http.Request) string {
+	if group, ok := routeGroups[r.URL.Path]; ok {
+		return group
+	}
+	return conf.ROUTES_SELF
+}
+
+// routeAuth returns authentication of route group of request, its type is
+// empty if authentication of the group is not configured
+func routeAuth(r *http.Request) model.RouteAuth {
+	return conf.GetConf().Auth[getRouteGroup(r)]
+}
+
+// Authenticate denies requests which are not authenticated as required by their route group
+func Authenticate(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		auth := routeAuth(r)
+		var err error
+		switch auth.Type {
+		case conf.AUTH_HMAC:
+			signedData := new(model.SignedInputData)
+			if err = model.GetStructFromParams(r, signedData); err == nil {
+				err = util.UnsignData(new(model.DummyStruct), *signedData)
 			}
+		case conf.AUTH_TOKEN:
+			err = checkToken(r, auth.Tokens)
+		case conf.AUTH_MTLS:
+			err = checkClientCert(r, auth.ClientNames)
+		}
+		if err != nil {
+			group := getRouteGroup(r)
+			metrics.UnauthorizedRequests.WithLabelValues(group, auth.Type).Inc()
+			glog.Warningln("Not authenticated request", remoteIP(r), r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func checkToken(r *http.Request, tokens []string) error {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return errors.New("Bearer token is required")
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return nil
+		}
+	}
+	return errors.New("Bad token")
+}
+
+func checkClientCert(r *http.Request, names []string) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return errors.New("Verified client certificate is required")
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, name := range names {
+		if name == cert.Subject.CommonName {
+			return nil
+		}
+		for _, dnsName := range cert.DNSNames {
+			if name == dnsName {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Client certificate %s is not allowed", cert.Subject.CommonName)
+}
+
+// AllowedRemoteAddress denies requests from addresses which are not in allowed
+// networks of the route group
+func AllowedRemoteAddress(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		group, addr := getRouteGroup(r), remoteIP(r)
+		if conf.GetConf().IsAllowedIP(group, net.ParseIP(addr)) {
+			h.ServeHTTP(w, r)
+			return
 		}
-		glog.Warningln("Request from not allowed IP", r.RemoteAddr)
+		metrics.DeniedRequests.WithLabelValues(group).Inc()
+		glog.Warningln("Request from not allowed IP", addr, r.URL.Path)
 		http.Error(w, "Not allowed remote address", http.StatusUnauthorized)
 	}
 	return http.HandlerFunc(fn)
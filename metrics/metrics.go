@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const NAMESPACE = "dialer"
+
+// Reasons of rejected cdr
+const (
+	BAD_CDR            = "bad_cdr"
+	UNEXISTING_NUMBERS = "unexisting_numbers"
+	WRONG_NUMBERS      = "wrong_numbers"
+)
+
+// Reasons of rejected signatures
+const (
+	BAD_SIGNATURE    = "bad_signature"
+	STALE_SIGNATURE  = "stale"
+	REPLAYED_NONCE   = "replayed"
+	MISSING_STAMP    = "missing_stamp"
+	NONCE_CACHE_FULL = "nonce_cache_full"
+)
+
+var (
+	AmiConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "ami_connected",
+		Help:      "Whether dialer is connected to asterisk manager interface.",
+	})
+	AmiReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "ami_reconnects_total",
+		Help:      "Number of reconnects to asterisk manager interface.",
+	})
+	AmiActionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "ami_action_duration_seconds",
+		Help:      "Time of asterisk response on action.",
+	}, []string{"action"})
+
+	CdrReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cdr_received_total",
+		Help:      "Number of cdr events received from asterisk.",
+	})
+	CdrRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cdr_rejected_total",
+		Help:      "Number of cdr events which were not saved, by reason.",
+	}, []string{"reason"})
+	CdrStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cdr_stored_total",
+		Help:      "Number of cdr saved to db.",
+	})
+	CdrSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cdr_sent_total",
+		Help:      "Number of cdr sent to portals.",
+	})
+	CdrFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cdr_failed_total",
+		Help:      "Number of failed attempts to send cdr to portals.",
+	})
+	CdrBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "cdr_backlog",
+		Help:      "Number of cdr waiting to be sent.",
+	})
+
+	PortalRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "portal_request_duration_seconds",
+		Help:      "Time of portal requests by api, country and status.",
+	}, []string{"api", "country", "status"})
+
+	PhoneCallBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "phone_call_backlog",
+		Help:      "Number of phone call records waiting to be converted and stored.",
+	})
+	TranscodeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "phone_call_transcode_duration_seconds",
+		Help:      "Time of conversion of phone call record to mp3.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 8),
+	})
+	UploadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "phone_call_upload_duration_seconds",
+		Help:      "Time of upload of phone call record to storage.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	InnerNumbers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "inner_numbers",
+		Help:      "Number of loaded inner numbers by country.",
+	}, []string{"country"})
+
+	DeniedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "denied_requests_total",
+		Help:      "Number of api requests from not allowed addresses by route group.",
+	}, []string{"group"})
+	UnauthorizedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "unauthorized_requests_total",
+		Help:      "Number of api requests which failed authentication by route group and its type.",
+	}, []string{"group", "type"})
+	SignaturesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "signatures_rejected_total",
+		Help:      "Number of rejected signed requests by country and reason.",
+	}, []string{"country", "reason"})
+	UnstampedSignatures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "unstamped_signatures_total",
+		Help:      "Number of signed requests accepted without stamp in compat mode by country.",
+	}, []string{"country"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		AmiConnected,
+		AmiReconnects,
+		AmiActionDuration,
+		CdrReceived,
+		CdrRejected,
+		CdrStored,
+		CdrSent,
+		CdrFailed,
+		CdrBacklog,
+		PortalRequestDuration,
+		PhoneCallBacklog,
+		TranscodeDuration,
+		UploadDuration,
+		InnerNumbers,
+		DeniedRequests,
+		UnauthorizedRequests,
+		SignaturesRejected,
+		UnstampedSignatures,
+	)
+}
@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/goji/param"
 )
 
+// Prefix of caller id name of calls placed from CRM, it is followed by CRM call id
+const CALL_FROM_CRM = "call_from_CRM:"
+
 type Set map[string]struct{}
 type Dict map[string]string
 type Response map[string]interface{}
@@ -23,6 +27,46 @@ func (r Response) String() string {
 type CountrySettings struct {
 	CompanyId string `json:"companyId"`
 	Secret    string `json:"secret"`
+	// IANA name of time zone of the country, like Europe/Kiev
+	TimeZone string `json:"timeZone"`
+	// Business hours in time zone of the country, like 09:00-18:00
+	BusinessHours string `json:"businessHours"`
+	// Hours of particular days of week, which override business hours,
+	// like {"sat": "10:00-15:00", "sun": "closed"}
+	WeeklyHours map[string]string `json:"weeklyHours"`
+	// Days off, like 2026-05-09, or 01-01 for every year
+	Holidays []string `json:"holidays"`
+	// Portal of the country, built-in portal of dialer target is used if it is not set
+	Portal Portal `json:"portal"`
+	// Replay protection of requests signed by the country, ReplayProtection
+	// of configuration is used if it is not set
+	ReplayProtection string `json:"replayProtection"`
+}
+
+type Portal struct {
+	// Base url, like https://my.prom.ua/
+	Url string `json:"url"`
+	// Prefix of api endpoints, Api of configuration is used if it is not set
+	Api string `json:"api"`
+	// Endpoints which differ from default ones, by their default names. Endpoint
+	// may be either a path after prefix or full url
+	Apis map[string]string `json:"apis"`
+}
+
+// Channel to which alerts are sent
+type AlertChannel struct {
+	// One of sms, webhook, smtp, slack
+	Type string `json:"type"`
+	// Url of sms gateway or webhook, address of smtp server like host:587
+	Url      string `json:"url"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// Phones for sms or emails for smtp
+	Recipients []string `json:"recipients"`
+	// Sender of emails
+	From string `json:"from"`
+	// Alerts with lower severity are not sent to the channel, recoveries are always sent
+	MinSeverity string `json:"minSeverity"`
 }
 
 type Call struct {
@@ -31,12 +75,22 @@ type Call struct {
 	UniqueId string `param:"uniqueId"`
 }
 
-func (c Call) GetChannel() string {
-	return fmt.Sprintf("SIP/%v", c.Inline)
+func (c Call) GetCallerID() string {
+	return fmt.Sprintf("%s%v <%v>", CALL_FROM_CRM, c.UniqueId, c.Inline)
 }
 
-func (c Call) GetCallerID() string {
-	return fmt.Sprintf("call_from_CRM:%v <%v>", c.UniqueId, c.Inline)
+type Spy struct {
+	Inline string `param:"inline" json:"inline"`
+	Exten  string `param:"exten" json:"exten"`
+	CallId string `param:"call_id" json:"call_id"`
+	Mode   string `param:"mode" json:"mode"`
+}
+
+type CallControl struct {
+	UniqueId    string `param:"unique_id" json:"unique_id"`
+	InnerNumber string `param:"inner_number" json:"inner_number"`
+	Exten       string `param:"exten" json:"exten"`
+	Attended    bool   `param:"attended" json:"attended"`
 }
 
 type CallInQueue struct {
@@ -44,14 +98,160 @@ type CallInQueue struct {
 	Country     string `param:"country"`
 }
 
+// Numbers of campaign with queue are dialed first and answered ones are put into the
+// queue. Without queue managers of callback queue are dialed first, as for callback requests
+type Campaign struct {
+	Name          string `param:"name" json:"name"`
+	Country       string `param:"country" json:"country"`
+	Queue         string `param:"queue" json:"queue"`
+	HoursFrom     string `param:"hours_from" json:"hours_from"`
+	HoursTo       string `param:"hours_to" json:"hours_to"`
+	MaxConcurrent int    `param:"max_concurrent" json:"max_concurrent"`
+	MaxAttempts   int    `param:"max_attempts" json:"max_attempts"`
+	RetryInterval int    `param:"retry_interval" json:"retry_interval"`
+	// Numbers may be provided either as comma separated list or as csv
+	// with numbers in the first column
+	Numbers string `param:"numbers" json:"numbers"`
+	Csv     string `param:"csv" json:"csv"`
+}
+
+type CampaignId struct {
+	Id int `param:"id" json:"id"`
+}
+
 type QueueContainer struct {
 	InnerNumber string `param:"inner_number" json:"inner_number"`
 	Queue       string `param:"queue" json:"queue"`
 }
 
+type CallEvent struct {
+	Type           string    `json:"type"`
+	UniqueId       string    `json:"unique_id"`
+	InnerNumber    string    `json:"inner_number"`
+	OpponentNumber string    `json:"opponent_number"`
+	Country        string    `json:"country"`
+	Queue          string    `json:"queue,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+type EventsFilter struct {
+	Country     string `param:"country" json:"country"`
+	InnerNumber string `param:"inner_number" json:"inner_number"`
+}
+
+// Filter of data which belongs to country, empty country is for all of them
+type CountryFilter struct {
+	Country string `param:"country" json:"country"`
+}
+
+func (f EventsFilter) Match(e CallEvent) bool {
+	if f.Country != "" && f.Country != e.Country {
+		return false
+	}
+	return f.InnerNumber == "" || f.InnerNumber == e.InnerNumber
+}
+
+type CallParticipant struct {
+	Channel        string `json:"channel"`
+	UniqueId       string `json:"unique_id"`
+	InnerNumber    string `json:"inner_number,omitempty"`
+	CallerIdNumber string `json:"caller_id_number"`
+	State          string `json:"state"`
+	Bridged        bool   `json:"bridged"`
+}
+
+type ActiveCall struct {
+	Id           string            `json:"id"`
+	Participants []CallParticipant `json:"participants"`
+	Direction    string            `json:"direction"`
+	Country      string            `json:"country"`
+	StartTime    time.Time         `json:"start_time"`
+	Duration     int               `json:"duration"`
+}
+
+type Presence struct {
+	InnerNumber string    `json:"inner_number"`
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+}
+
+type QueuePause struct {
+	InnerNumber string `param:"inner_number" json:"inner_number"`
+	Queue       string `param:"queue" json:"queue"`
+	Paused      bool   `param:"paused" json:"paused"`
+	Reason      string `param:"reason" json:"reason"`
+}
+
+type QueuePenalty struct {
+	InnerNumber string `param:"inner_number" json:"inner_number"`
+	Queue       string `param:"queue" json:"queue"`
+	Penalty     int    `param:"penalty" json:"penalty"`
+}
+
+// Member of queue. Location is interface with which it was added to queue, it is
+// used to remove member, and Interface is the one which state is tracked
+type QueueMember struct {
+	Queue        string     `json:"queue"`
+	Name         string     `json:"name"`
+	Interface    string     `json:"interface"`
+	Location     string     `json:"location"`
+	InnerNumber  string     `json:"inner_number"`
+	Country      string     `json:"country,omitempty"`
+	Membership   string     `json:"membership"`
+	Penalty      int        `json:"penalty"`
+	CallsTaken   int        `json:"calls_taken"`
+	LastCall     *time.Time `json:"last_call"`
+	Status       string     `json:"status"`
+	Paused       bool       `json:"paused"`
+	PausedReason string     `json:"paused_reason,omitempty"`
+}
+
+type QueueSummary struct {
+	Queue           string `json:"queue"`
+	LoggedIn        int    `json:"logged_in"`
+	Available       int    `json:"available"`
+	Callers         int    `json:"callers"`
+	HoldTime        int    `json:"hold_time"`
+	TalkTime        int    `json:"talk_time"`
+	LongestHoldTime int    `json:"longest_hold_time"`
+}
+
+type AuditQuery struct {
+	Route   string `param:"route" json:"route"`
+	Country string `param:"filter_country" json:"filter_country"`
+	// Time in RFC3339 format
+	From  string `param:"from" json:"from"`
+	To    string `param:"to" json:"to"`
+	Limit int    `param:"limit" json:"limit"`
+}
+
+type LogLevel struct {
+	Component string `param:"component" json:"component"`
+	Level     string `param:"level" json:"level"`
+}
+
 type DialerStats struct {
-	Name    string
-	DBCount int
+	Name   string `json:"name"`
+	Uptime int    `json:"uptime"`
+	Ami    struct {
+		Connected  bool `json:"connected"`
+		Reconnects int  `json:"reconnects"`
+	} `json:"ami"`
+	// Not sent cdr by country
+	CdrBacklog       map[string]int `json:"cdr_backlog"`
+	PhoneCallBacklog int            `json:"phone_call_backlog"`
+	// Age in seconds of the oldest not sent cdr
+	OldestPendingAge int `json:"oldest_pending_age"`
+	// Time of the last successful request to portal by country
+	LastPortalSends map[string]time.Time    `json:"last_portal_sends"`
+	InnerNumbers    map[string]NumbersStats `json:"inner_numbers"`
+	Queues          []QueueSummary          `json:"queues"`
+	QueuesError     string                  `json:"queues_error,omitempty"`
+}
+
+type NumbersStats struct {
+	Loaded     int `json:"loaded"`
+	Duplicates int `json:"duplicates"`
 }
 
 type PhoneCall struct {
@@ -67,6 +267,25 @@ type SignedInputData struct {
 	Data    string `param:"data"`
 }
 
+// Authentication of requests to a group of routes
+type RouteAuth struct {
+	// One of none, hmac, token, mtls
+	Type string `json:"type"`
+	// Tokens which are accepted in header Authorization: Bearer <token>
+	Tokens []string `json:"tokens"`
+	// Common names or DNS names of accepted client certificates. Any certificate
+	// signed by ClientCA of configuration is accepted if it is empty
+	ClientNames []string `json:"clientNames"`
+}
+
+// Stamp of signed data, which protects it from replay. Data is accepted only once
+// and only if it was issued not earlier or later than allowed clock skew
+type SignatureStamp struct {
+	// Unix time in seconds
+	IssuedAt int64  `json:"issued_at"`
+	Nonce    string `json:"nonce"`
+}
+
 type Cdr struct {
 	Id       int    `param:"id"`
 	UniqueID string `param:"unique_id"`
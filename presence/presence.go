@@ -0,0 +1,127 @@
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/warik/gami"
+
+	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/util"
+)
+
+const (
+	IDLE         = "idle"
+	RINGING      = "ringing"
+	IN_USE       = "in-use"
+	ON_HOLD      = "on-hold"
+	UNREGISTERED = "unregistered"
+)
+
+var (
+	// AMI events from which presence of inner numbers is built
+	AMI_EVENTS = []string{"ExtensionStatus", "DeviceStateChange", "PeerStatus"}
+
+	deviceStates = map[string]string{
+		"NOT_INUSE":   IDLE,
+		"INUSE":       IN_USE,
+		"BUSY":        IN_USE,
+		"RINGINUSE":   IN_USE,
+		"RINGING":     RINGING,
+		"ONHOLD":      ON_HOLD,
+		"UNAVAILABLE": UNREGISTERED,
+		"INVALID":     UNREGISTERED,
+	}
+	extensionStates = map[string]string{
+		"-2": UNREGISTERED,
+		"-1": UNREGISTERED,
+		"0":  IDLE,
+		"1":  IN_USE,
+		"2":  IN_USE,
+		"4":  UNREGISTERED,
+		"8":  RINGING,
+		"9":  IN_USE,
+		"16": ON_HOLD,
+		"17": ON_HOLD,
+	}
+
+	presences = struct {
+		sync.RWMutex
+		m map[string]model.Presence
+	}{m: map[string]model.Presence{}}
+)
+
+// Update applies asterisk event to presence map and returns new presence of
+// inner number, if it was changed by the event
+func Update(m gami.Message) (model.Presence, bool) {
+	var innerNumber, state string
+	switch m["Event"] {
+	case "ExtensionStatus":
+		innerNumber, state = m["Exten"], extensionStates[m["Status"]]
+	case "DeviceStateChange":
+		innerNumber, state = getNumber(m["Device"]), deviceStates[m["State"]]
+	case "PeerStatus":
+		innerNumber = getNumber(m["Peer"])
+	}
+	if innerNumber == "" {
+		return model.Presence{}, false
+	}
+
+	// Current state is read and replaced under one lock, otherwise concurrent
+	// events may lose updates or report the same transition twice
+	presences.Lock()
+	defer presences.Unlock()
+	p, ok := presences.m[innerNumber]
+	if m["Event"] == "PeerStatus" {
+		switch m["PeerStatus"] {
+		case "Unregistered", "Unreachable", "Rejected":
+			state = UNREGISTERED
+		case "Registered", "Reachable":
+			// Registration tells nothing about calls, so only leave unregistered
+			// state here and let device state events tell the rest
+			if !ok || p.State == UNREGISTERED {
+				state = IDLE
+			}
+		}
+	}
+	if state == "" || ok && p.State == state {
+		return p, false
+	}
+	p = model.Presence{InnerNumber: innerNumber, State: state, Since: time.Now().UTC()}
+	presences.m[innerNumber] = p
+	return p, true
+}
+
+func Get(innerNumber string) model.Presence {
+	presences.RLock()
+	defer presences.RUnlock()
+	return presences.m[innerNumber]
+}
+
+// All returns presence of inner numbers, optionally only for provided country
+func All(country string) []model.Presence {
+	presences.RLock()
+	defer presences.RUnlock()
+	result := []model.Presence{}
+	for innerNumber, p := range presences.m {
+		if country != "" && !contains(util.GetCountriesByNumber(innerNumber), country) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+func getNumber(iface string) string {
+	innerNumber, _ := util.ParseInterface(iface)
+	return innerNumber
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
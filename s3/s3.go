@@ -3,6 +3,7 @@ package s3
 import (
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"sync"
 
 	"github.com/goamz/goamz/aws"
@@ -11,20 +12,49 @@ import (
 	"github.com/warik/go-dialer/conf"
 )
 
-var bucket *s3.Bucket
-var once sync.Once
+var (
+	bucket *s3.Bucket
+	lock   sync.Mutex
+)
 
 func Store(filePath, fileName string) error {
-	once.Do(initS3)
+	b, err := getBucket()
+	if err != nil {
+		return err
+	}
 
 	data, err := ioutil.ReadFile(fmt.Sprintf("%s_mp3/%s", filePath, fileName))
 	if err != nil {
 		return err
 	}
-	return bucket.Put(fileName, data, "audio/mpeg", s3.Private, s3.Options{})
+	return b.Put(fileName, data, "audio/mpeg", s3.Private, s3.Options{})
 }
 
-func initS3() {
+// ConfChanged drops bucket if storage settings were changed, so it is created
+// again with new settings on the next store
+func ConfChanged(old, new *conf.Configuration) {
+	if old.Name == new.Name && reflect.DeepEqual(old.StorageSettings, new.StorageSettings) {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	bucket = nil
+}
+
+func getBucket() (*s3.Bucket, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if bucket == nil {
+		b, err := initS3()
+		if err != nil {
+			return nil, err
+		}
+		bucket = b
+	}
+	return bucket, nil
+}
+
+func initS3() (*s3.Bucket, error) {
 	accessKey := conf.GetConf().StorageSettings["accessKey"]
 	secretKey := conf.GetConf().StorageSettings["secretKey"]
 	s3Host := conf.GetConf().StorageSettings["s3Host"]
@@ -39,9 +69,9 @@ func initS3() {
 		S3Endpoint: s3Host,
 	}
 	client := s3.New(auth, region)
-	bucket = client.Bucket(fmt.Sprintf("%s/%s", baseBucket, dialerName))
-	err := bucket.PutBucket(s3.Private)
-	if err != nil {
-		panic(err)
+	b := client.Bucket(fmt.Sprintf("%s/%s", baseBucket, dialerName))
+	if err := b.PutBucket(s3.Private); err != nil {
+		return nil, err
 	}
+	return b, nil
 }
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/metrics"
+	"github.com/warik/go-dialer/model"
+)
+
+var nonces = nonceCache{seen: map[string]time.Time{}}
+
+// nonceCache keeps nonces of accepted signed data until their stamps become
+// stale. It holds no more than NonceCacheSize nonces, new signed data is rejected
+// while it is full, since forgetting nonces which are not stale would allow replay
+type nonceCache struct {
+	sync.Mutex
+	seen map[string]time.Time
+}
+
+var (
+	errReplayedNonce  = errors.New("Signed data was already used")
+	errNonceCacheFull = errors.New("Too many signed requests, try again later")
+)
+
+// add saves nonce if it was not seen before and there is room for it
+func (c *nonceCache) add(key string, expires time.Time, size int) error {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := c.seen[key]; ok && !expiresAt.Before(now) {
+		return errReplayedNonce
+	}
+	if len(c.seen) >= size {
+		for k, expiresAt := range c.seen {
+			if expiresAt.Before(now) {
+				delete(c.seen, k)
+			}
+		}
+	}
+	if len(c.seen) >= size {
+		glog.Warningln("Nonce cache is full, signed data is rejected")
+		return errNonceCacheFull
+	}
+	c.seen[key] = expires
+	return nil
+}
+
+// metricCountry returns country for labels of metrics. Country of request is not
+// verified yet, so unknown ones are counted together to keep number of series bounded
+func metricCountry(country string) string {
+	if _, ok := conf.GetConf().Agencies[country]; ok {
+		return country
+	}
+	return "unknown"
+}
+
+// checkStamp rejects stale and already accepted signed data of the country.
+// Data without stamp is accepted only in compat mode
+func checkStamp(country string, stamp model.SignatureStamp) error {
+	config := conf.GetConf()
+	if stamp.IssuedAt == 0 && stamp.Nonce == "" {
+		if config.GetReplayProtection(country) == conf.REPLAY_STRICT {
+			metrics.SignaturesRejected.WithLabelValues(metricCountry(country), metrics.MISSING_STAMP).Inc()
+			return errors.New("Signed data should have issued_at and nonce")
+		}
+		metrics.UnstampedSignatures.WithLabelValues(metricCountry(country)).Inc()
+		return nil
+	}
+	if stamp.IssuedAt == 0 || stamp.Nonce == "" {
+		metrics.SignaturesRejected.WithLabelValues(metricCountry(country), metrics.MISSING_STAMP).Inc()
+		return errors.New("Signed data should have both issued_at and nonce")
+	}
+
+	skew := config.GetSignatureClockSkew()
+	issuedAt := time.Unix(stamp.IssuedAt, 0)
+	if diff := time.Since(issuedAt); diff > skew || diff < -skew {
+		metrics.SignaturesRejected.WithLabelValues(metricCountry(country), metrics.STALE_SIGNATURE).Inc()
+		return errors.New("Signed data is stale")
+	}
+	err := nonces.add(country+":"+stamp.Nonce, issuedAt.Add(skew), config.GetNonceCacheSize())
+	switch err {
+	case errReplayedNonce:
+		metrics.SignaturesRejected.WithLabelValues(metricCountry(country), metrics.REPLAYED_NONCE).Inc()
+	case errNonceCacheFull:
+		metrics.SignaturesRejected.WithLabelValues(metricCountry(country), metrics.NONCE_CACHE_FULL).Inc()
+	}
+	return err
+}
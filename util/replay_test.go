@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceCache(t *testing.T) {
+	c := nonceCache{seen: map[string]time.Time{}}
+	now := time.Now()
+
+	if err := c.add("ua:1", now.Add(time.Minute), 2); err != nil {
+		t.Fatalf("new nonce: %v", err)
+	}
+	if err := c.add("ua:1", now.Add(time.Minute), 2); err != errReplayedNonce {
+		t.Errorf("replayed nonce: got %v, want %v", err, errReplayedNonce)
+	}
+	if err := c.add("ua:2", now.Add(-time.Second), 2); err != nil {
+		t.Fatalf("second nonce: %v", err)
+	}
+	// Stale nonce gives room for new one
+	if err := c.add("ua:3", now.Add(time.Minute), 2); err != nil {
+		t.Errorf("nonce after stale one: %v", err)
+	}
+	// Live nonces are not forgotten when cache is full
+	if err := c.add("ua:4", now.Add(time.Minute), 2); err != errNonceCacheFull {
+		t.Errorf("nonce in full cache: got %v, want %v", err, errNonceCacheFull)
+	}
+	if err := c.add("ua:1", now.Add(time.Minute), 2); err != errReplayedNonce {
+		t.Errorf("replayed nonce in full cache: got %v, want %v", err, errReplayedNonce)
+	}
+}
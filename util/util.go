@@ -3,12 +3,15 @@ package util
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"hash"
 	"os/exec"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,9 +19,10 @@ import (
 	"github.com/golang/glog"
 	"github.com/parnurzeal/gorequest"
 	"github.com/vmihailenco/signer"
-	"github.com/warik/gami"
 
 	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/logger"
+	"github.com/warik/go-dialer/metrics"
 	"github.com/warik/go-dialer/model"
 )
 
@@ -33,8 +37,15 @@ const (
 
 var (
 	PHONE_RE          *regexp.Regexp
+	INTERFACE_RE      *regexp.Regexp
 	InnerPhoneNumbers InnerPhones
 	callbackCdrCache  = NewSafeMap()
+	portalLog         = logger.New("portal")
+	// Time of the last successful request to portal by country
+	portalSends = struct {
+		sync.RWMutex
+		m map[string]time.Time
+	}{m: map[string]time.Time{}}
 )
 
 type InnerPhones struct {
@@ -63,6 +74,56 @@ func LoadInnerNumbers(numbersChan chan<- []string) {
 	}
 }
 
+func LastPortalSends() map[string]time.Time {
+	portalSends.RLock()
+	defer portalSends.RUnlock()
+	sends := map[string]time.Time{}
+	for country, t := range portalSends.m {
+		sends[country] = t
+	}
+	return sends
+}
+
+// GetNumbersStats returns number of loaded inner numbers and of those which
+// are duplicated in other countries
+func GetNumbersStats() map[string]model.NumbersStats {
+	InnerPhoneNumbers.RLock()
+	defer InnerPhoneNumbers.RUnlock()
+	stats := map[string]model.NumbersStats{}
+	for country, numbers := range InnerPhoneNumbers.NumbersMap {
+		s := model.NumbersStats{Loaded: len(numbers)}
+		for number := range numbers {
+			if _, ok := InnerPhoneNumbers.DuplicateNumbers[number]; ok {
+				s.Duplicates++
+			}
+		}
+		stats[country] = s
+	}
+	return stats
+}
+
+// ParseTime parses time stored as RFC3339 or, if it was saved before, in
+// format 2006-01-02 15:04:05 in UTC
+func ParseTime(t string) (time.Time, error) {
+	tt, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return time.Parse(TIME_FORMAT, t)
+	}
+	return tt, nil
+}
+
+// ForgetCountries removes inner numbers of countries which are not in agencies anymore
+func ForgetCountries(agencies map[string]model.CountrySettings) {
+	InnerPhoneNumbers.Lock()
+	defer InnerPhoneNumbers.Unlock()
+	for country := range InnerPhoneNumbers.NumbersMap {
+		if _, ok := agencies[country]; !ok {
+			delete(InnerPhoneNumbers.NumbersMap, country)
+			metrics.InnerNumbers.DeleteLabelValues(country)
+		}
+	}
+}
+
 type SafeMap struct {
 	Map map[string]interface{}
 	*sync.RWMutex
@@ -123,14 +184,76 @@ func signData(body []byte, secret string) (signedData string, err error) {
 	return
 }
 
+// ConvertTime converts time reported by asterisk in its own time zone to UTC in
+// RFC3339 format. Empty time, like answer time of not answered call, stays empty
 func ConvertTime(t string) string {
-	tt, err := time.Parse(TIME_FORMAT, t)
+	if t == "" {
+		return ""
+	}
+	tt, err := time.ParseInLocation(TIME_FORMAT, t, conf.GetConf().GetAsteriskLocation())
 	if err != nil {
 		glog.Errorln(err)
 		return ""
 	}
-	timeZoneShift := time.Duration(-conf.GetConf().TimeZone) * time.Hour
-	return tt.Add(timeZoneShift).Format(TIME_FORMAT)
+	return tt.UTC().Format(time.RFC3339)
+}
+
+// LocalTime converts UTC time to local time of the country in RFC3339 format
+func LocalTime(t, country string) string {
+	if t == "" {
+		return ""
+	}
+	tt, err := ParseTime(t)
+	if err != nil {
+		glog.Errorln(err)
+		return ""
+	}
+	return tt.In(conf.GetConf().GetLocation(country)).Format(time.RFC3339)
+}
+
+// PortalTime converts stored UTC time to format 2006-01-02 15:04:05, in which
+// portal expects start time of cdr
+func PortalTime(t string) string {
+	if t == "" {
+		return ""
+	}
+	tt, err := ParseTime(t)
+	if err != nil {
+		glog.Errorln(err)
+		return ""
+	}
+	return tt.UTC().Format(TIME_FORMAT)
+}
+
+// ParseNumbers returns unique phone numbers from comma separated list and from
+// the first column of csv. Rows which do not look like phone number, like header, are skipped
+func ParseNumbers(list, csvData string) ([]string, error) {
+	values := strings.Split(list, ",")
+	if csvData != "" {
+		records, err := csv.NewReader(strings.NewReader(csvData)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if len(record) > 0 {
+				values = append(values, record[0])
+			}
+		}
+	}
+
+	numbers := []string{}
+	seen := model.Set{}
+	for _, value := range values {
+		number := strings.TrimSpace(value)
+		if number == "" || !strings.ContainsAny(number[:1], "+0123456789") {
+			continue
+		}
+		if _, ok := seen[number]; !ok {
+			seen[number] = struct{}{}
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers, nil
 }
 
 func ConvertWAV2MP3(dirName, wavFileName, mp3FileName string) error {
@@ -147,24 +270,26 @@ func GetPhoneCallFileName(dialerName, uniqueId, exten string) string {
 	return fmt.Sprintf("%s-%s.%s", dialerName, uniqueId, exten)
 }
 
-func GetActiveQueuesMap(activeQueuesChan <-chan gami.Message) (
-	queuesNumberMap map[string]map[string][]string) {
-	queuesNumberMap = make(map[string]map[string][]string)
-	for len(activeQueuesChan) > 0 {
-		qm := <-activeQueuesChan
-		queue, number, country := qm["Queue"], qm["Name"][6:10], qm["Name"][10:12]
-		// If its static queue then it doesn't attach to country, so just skip
-		if country != "ua" && country != "ru" {
+// GetActiveQueuesMap groups dynamic members of queues by country and inner number.
+// Member without country in its interface belongs to all countries with its number
+func GetActiveQueuesMap(members []model.QueueMember) (
+	queuesNumberMap map[string]map[string][]model.QueueMember) {
+	queuesNumberMap = make(map[string]map[string][]model.QueueMember)
+	for _, member := range members {
+		// Static members are set in asterisk config and cannot be managed
+		if member.Membership == "static" || member.InnerNumber == "" {
 			continue
 		}
-		if _, ok := queuesNumberMap[country]; !ok {
-			queuesNumberMap[country] = make(map[string][]string)
+		countries := []string{member.Country}
+		if member.Country == "" {
+			countries = GetCountriesByNumber(member.InnerNumber)
 		}
-		activeQueues, ok := queuesNumberMap[country][number]
-		if !ok {
-			queuesNumberMap[country][number] = []string{queue}
-		} else {
-			queuesNumberMap[country][number] = append(activeQueues, queue)
+		for _, country := range countries {
+			if _, ok := queuesNumberMap[country]; !ok {
+				queuesNumberMap[country] = make(map[string][]model.QueueMember)
+			}
+			queuesNumberMap[country][member.InnerNumber] = append(
+				queuesNumberMap[country][member.InnerNumber], member)
 		}
 	}
 	return
@@ -201,6 +326,9 @@ func GetCountryByPhones(innerPhoneNumber, opponentPhoneNumber string) (countryCo
 	//	In other case just return country from map
 	if _, ok := InnerPhoneNumbers.DuplicateNumbers[innerPhoneNumber]; ok {
 		outerNumber := strings.TrimPrefix(opponentPhoneNumber, "+")
+		if len(outerNumber) < 3 {
+			return
+		}
 		if outerNumber[:3] == "380" || (outerNumber[:1] == "0" && len(outerNumber) == 10) {
 			countryCode = "ua"
 		} else if outerNumber[:2] == "77" {
@@ -222,6 +350,69 @@ func GetCountryByPhones(innerPhoneNumber, opponentPhoneNumber string) (countryCo
 	return
 }
 
+// GetCountriesByNumber returns all countries where inner number is present,
+// there may be several of them for duplicated numbers
+func GetCountriesByNumber(innerPhoneNumber string) (countries []string) {
+	InnerPhoneNumbers.RLock()
+	defer InnerPhoneNumbers.RUnlock()
+	for country, numbers := range InnerPhoneNumbers.NumbersMap {
+		if _, ok := numbers[innerPhoneNumber]; ok {
+			countries = append(countries, country)
+		}
+	}
+	return
+}
+
+// ParseInterface returns inner number from interface like SIP/1234. Local interfaces
+// of queue members may also contain country of the number, like Local/1234ua@context
+func ParseInterface(iface string) (innerNumber, country string) {
+	if numberArr := INTERFACE_RE.FindStringSubmatch(iface); numberArr != nil {
+		return numberArr[1], numberArr[2]
+	}
+	return "", ""
+}
+
+func SendCallStatus(crmCallId, innerPhoneNumber, phone, party, state, country string) (
+	string, error) {
+	settings := conf.GetConf().Agencies[country]
+	payload, _ := json.Marshal(model.Dict{
+		"call_id":      crmCallId,
+		"inner_number": innerPhoneNumber,
+		"phone":        phone,
+		"party":        party,
+		"state":        state,
+		"CompanyId":    settings.CompanyId,
+	})
+	url := conf.GetConf().GetApi(country, conf.GetConf().CallStatusApi)
+	return SendRequest(payload, url, "POST", settings.Secret, settings.CompanyId)
+}
+
+func SendCallBackStatus(id int, phoneNumber, status, result string, attempts int,
+	country string) (string, error) {
+	settings := conf.GetConf().Agencies[country]
+	payload, _ := json.Marshal(model.Dict{
+		"id":           strconv.Itoa(id),
+		"phone_number": phoneNumber,
+		"status":       status,
+		"result":       result,
+		"attempts":     strconv.Itoa(attempts),
+		"CompanyId":    settings.CompanyId,
+	})
+	url := conf.GetConf().GetApi(country, conf.GetConf().CallBackStatusApi)
+	return SendRequest(payload, url, "POST", settings.Secret, settings.CompanyId)
+}
+
+func SendPresence(innerPhoneNumber, state, country string) (string, error) {
+	settings := conf.GetConf().Agencies[country]
+	payload, _ := json.Marshal(model.Dict{
+		"inner_number": innerPhoneNumber,
+		"state":        state,
+		"CompanyId":    settings.CompanyId,
+	})
+	url := conf.GetConf().GetApi(country, "save_manager_presence")
+	return SendRequest(payload, url, "POST", settings.Secret, settings.CompanyId)
+}
+
 func GetCallBackPhoneDetails(channel, destination, destinationChannel string) (
 	innerNum string, externNum string, callType int) {
 	callbackCdrCache.Lock()
@@ -301,23 +492,63 @@ func IsNumbersValid(innerPhoneNumber, opponentPhoneNumber, countryCode string) b
 	return len(opponentPhoneNumber) >= 7
 }
 
+// UnsignData checks signature and stamp of signed data and decodes it to i.
+// Every signed data is accepted only once
 func UnsignData(i interface{}, d model.SignedInputData) (err error) {
+	dataString, err := verifySignature(d)
+	if err != nil {
+		metrics.SignaturesRejected.WithLabelValues(metricCountry(d.Country), metrics.BAD_SIGNATURE).Inc()
+		return
+	}
+	stamp := model.SignatureStamp{}
+	if err = json.Unmarshal(dataString, &stamp); err != nil {
+		return
+	}
+	if err = checkStamp(d.Country, stamp); err != nil {
+		return
+	}
+	return json.Unmarshal(dataString, &i)
+}
+
+// DecodeSignedData checks only signature of signed data and decodes it to i,
+// it is for data which was already accepted by UnsignData
+func DecodeSignedData(i interface{}, d model.SignedInputData) error {
+	dataString, err := verifySignature(d)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(dataString, &i)
+}
+
+// verifySignature checks signature by secret of the country, data of unknown
+// countries and countries without secret is never accepted
+func verifySignature(d model.SignedInputData) ([]byte, error) {
+	settings, ok := conf.GetConf().Agencies[d.Country]
+	if !ok || settings.Secret == "" {
+		return nil, fmt.Errorf("Unknown country %q", d.Country)
+	}
 	h := hmac.New(func() hash.Hash {
 		return sha1.New()
-	}, []byte(getKey(conf.GetConf().Agencies[d.Country].Secret)))
+	}, []byte(getKey(settings.Secret)))
 	dataString, ok := signer.NewBase64Signer(h).Verify([]byte(d.Data))
 
 	signatureData := strings.Split(d.Data, ".")
 	if !ok || len(signatureData) < 2 {
-		return errors.New("Bad signature")
+		return nil, errors.New("Bad signature")
 	}
-	err = json.Unmarshal(dataString, &i)
-	dataString, signatureData = nil, nil
-	return
+	return dataString, nil
 }
 
 func SendRequest(payload []byte, url, method, secret, companyId string) (string, error) {
-	glog.Infoln(fmt.Sprintf("Sending request to %v", url))
+	country := conf.GetConf().GetCountryByCompanyId(companyId)
+	log := portalLog.With(logger.Fields{"api": path.Base(url), "country": country})
+	log.Debug("Sending request", logger.Fields{"url": url, "method": method})
+	start, status := time.Now(), "error"
+	defer func() {
+		duration := time.Since(start).Seconds()
+		metrics.PortalRequestDuration.WithLabelValues(path.Base(url), country, status).Observe(duration)
+		log.Info("Portal request finished", logger.Fields{"status": status, "duration": duration})
+	}()
 	signedData, err := signData(payload, secret)
 	if err != nil {
 		return "", err
@@ -337,14 +568,23 @@ func SendRequest(payload []byte, url, method, secret, companyId string) (string,
 		return "", errs[0]
 	}
 
+	status = strconv.Itoa(resp.StatusCode)
 	if resp.StatusCode != 200 {
 		return "", fmt.Errorf(conf.REMOTE_ERROR_TEXT, resp.StatusCode)
 	}
+	if country != "" {
+		portalSends.Lock()
+		portalSends.m[country] = time.Now().UTC()
+		portalSends.Unlock()
+	}
 
 	return respBody, nil
 }
 
 func init() {
+	// Channel name of inner number for any technology, like SIP/1234-0000001a
+	// or PJSIP/1234-0000001a
 	PHONE_RE, _ = regexp.Compile("^\\w+/(\\d{2,4}|\\d{4}\\w{2})\\D*-.+$")
+	INTERFACE_RE, _ = regexp.Compile("^\\w+/(\\d{2,4})([a-z]{2})?(@.+)?$")
 	InnerPhoneNumbers = InnerPhones{model.Set{}, map[string]model.Set{}, new(sync.RWMutex)}
 }
@@ -3,21 +3,35 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/golang/glog"
+	"github.com/warik/gami"
 
+	"github.com/warik/go-dialer/alert"
+	"github.com/warik/go-dialer/ami"
 	"github.com/warik/go-dialer/conf"
 	"github.com/warik/go-dialer/db"
+	"github.com/warik/go-dialer/logger"
+	"github.com/warik/go-dialer/metrics"
 	"github.com/warik/go-dialer/model"
 	"github.com/warik/go-dialer/s3"
 	"github.com/warik/go-dialer/util"
 )
 
+var (
+	recordingLog = logger.New("recording")
+	campaignLog  = logger.New("campaign")
+	callbackLog  = logger.New("callback")
+)
+
 func CdrReader(ctx context.Context, wg *sync.WaitGroup, mChan chan<- db.CDR, ticker *time.Ticker) {
 	glog.Infoln("Initiating CdrReader...")
 	wg.Add(1)
@@ -35,11 +49,13 @@ func CdrReader(ctx context.Context, wg *sync.WaitGroup, mChan chan<- db.CDR, tic
 			case <-ticker.C:
 				cdrs, err := db.GetDB().SelectCDRs(conf.MAX_CDR_NUMBER)
 				if err != nil {
-					conf.Alert(fmt.Sprintf("Cannot read from cdr | %s", err))
+					alert.Raise("cdr_read", alert.CRITICAL, fmt.Sprintf("Cannot read from cdr | %s", err))
 					glog.Errorln(err)
 					continue
 				}
+				alert.Resolve("cdr_read", "Reading from cdr is restored")
 				dbCount := db.GetDB().GetCdrCount()
+				metrics.CdrBacklog.Set(float64(dbCount))
 				glog.Infoln(fmt.Sprintf("<<< READING CDRS | DB: %d | PROCESS: %d", dbCount, len(cdrs)))
 
 				for _, cdr := range cdrs {
@@ -47,7 +63,9 @@ func CdrReader(ctx context.Context, wg *sync.WaitGroup, mChan chan<- db.CDR, tic
 				}
 
 				if dbCount >= 2*conf.MAX_CDR_NUMBER {
-					conf.Alert(fmt.Sprintf("Overload with cdr, %d", dbCount))
+					alert.Raise("cdr_overload", alert.WARNING, fmt.Sprintf("Overload with cdr, %d", dbCount))
+				} else {
+					alert.Resolve("cdr_overload", fmt.Sprintf("Cdr are not overloaded, %d", dbCount))
 				}
 
 				glog.Flush()
@@ -72,18 +90,24 @@ func CdrSender(ctx context.Context, wg *sync.WaitGroup, mChan <-chan db.CDR, i i
 			case cdr := <-mChan:
 				settings := conf.GetConf().Agencies[cdr.CountryCode]
 				url := conf.GetConf().GetApi(cdr.CountryCode, "save_phone_call")
+				cdr.LocalStartTime = util.LocalTime(cdr.StartTime, cdr.CountryCode)
+				cdr.StartTime = util.PortalTime(cdr.StartTime)
 				data, _ := json.Marshal(cdr)
+				log := cdrLog.With(logger.Fields{"unique_id": cdr.UniqueID,
+					"inner_number": cdr.InnerPhoneNumber, "country": cdr.CountryCode})
 				_, err := util.SendRequest(data, url, "POST", settings.Secret, settings.CompanyId)
 				if err == nil {
-					glog.Infoln("<<< CDR SENT", "|", cdr.UniqueID)
+					metrics.CdrSent.Inc()
+					log.Info("Cdr sent")
 					res, err := db.GetDB().DeleteCdr(cdr.ID)
 					if err != nil {
-						glog.Errorln("Error while deleting message - ", cdr.UniqueID, err)
+						log.Error("Cannot delete cdr", logger.Fields{"error": err})
 					} else if count, _ := res.RowsAffected(); count != 1 {
-						glog.Errorln("CDR was not deleted - ", cdr.UniqueID)
+						log.Error("Cdr was not deleted")
 					}
 				} else {
-					glog.Errorln("<<< ERROR WHILE SENDING", "|", cdr.UniqueID, err)
+					metrics.CdrFailed.Inc()
+					log.Error("Cannot send cdr", logger.Fields{"error": err})
 				}
 			}
 		}
@@ -108,11 +132,14 @@ func PhoneCallReader(ctx context.Context, wg *sync.WaitGroup, pcChan chan<- db.P
 			case <-ticker.C:
 				phoneCalls, err := db.GetDB().SelectPhoneCalls(conf.MAX_PHONE_CALLS_NUMBER)
 				if err != nil {
-					conf.Alert(fmt.Sprintf("Cannot read from phone_call | %s", err))
+					alert.Raise("phone_call_read", alert.CRITICAL,
+						fmt.Sprintf("Cannot read from phone_call | %s", err))
 					glog.Errorln(err)
 					continue
 				}
+				alert.Resolve("phone_call_read", "Reading from phone_call is restored")
 				dbCount := db.GetDB().GetPhoneCallCount()
+				metrics.PhoneCallBacklog.Set(float64(dbCount))
 				glog.Infoln(fmt.Sprintf(
 					"<<< READING PHONE_CALLS | DB: %d | PROCESS: %d",
 					dbCount,
@@ -124,7 +151,11 @@ func PhoneCallReader(ctx context.Context, wg *sync.WaitGroup, pcChan chan<- db.P
 				}
 
 				if dbCount >= 2*conf.MAX_PHONE_CALLS_NUMBER {
-					conf.Alert(fmt.Sprintf("Overload with phone calls, %d", dbCount))
+					alert.Raise("phone_call_overload", alert.WARNING,
+						fmt.Sprintf("Overload with phone calls, %d", dbCount))
+				} else {
+					alert.Resolve("phone_call_overload",
+						fmt.Sprintf("Phone calls are not overloaded, %d", dbCount))
 				}
 			}
 		}
@@ -153,18 +184,27 @@ func PhoneCallSender(ctx context.Context, wg *sync.WaitGroup,
 				mp3FileName := util.GetPhoneCallFileName(dialerName,
 					phoneCall.UniqueID, "mp3")
 
-				glog.Infoln("<<< Processing Phone Call", wavFileName)
+				log := recordingLog.With(logger.Fields{"unique_id": phoneCall.UniqueID})
+				log.Info("Processing phone call", logger.Fields{"file": wavFileName})
 
+				start := time.Now()
 				err := util.ConvertWAV2MP3(dirName, wavFileName, mp3FileName)
 				if err != nil {
-					glog.Errorln(err)
+					log.Error("Cannot convert phone call", logger.Fields{"error": err})
 					continue
 				}
+				transcodeDuration := time.Since(start)
+				metrics.TranscodeDuration.Observe(transcodeDuration.Seconds())
+				start = time.Now()
 				if err = s3.Store(dirName, mp3FileName); err != nil {
-					glog.Errorln(err)
+					log.Error("Cannot store phone call", logger.Fields{"error": err})
 					continue
 				}
+				uploadDuration := time.Since(start)
+				metrics.UploadDuration.Observe(uploadDuration.Seconds())
 				db.GetDB().DeletePhoneCall(phoneCall.ID)
+				log.Info("Phone call stored", logger.Fields{"file": mp3FileName,
+					"transcode_duration": transcodeDuration.Seconds(), "upload_duration": uploadDuration.Seconds()})
 			}
 		}
 	}()
@@ -206,78 +246,394 @@ func NumbersLoader(ctx context.Context, wg *sync.WaitGroup,
 				glog.Infoln("Duplicated numbers", util.InnerPhoneNumbers.DuplicateNumbers)
 
 				util.InnerPhoneNumbers.NumbersMap[countryCode] = tNumbersSet
+				metrics.InnerNumbers.WithLabelValues(countryCode).Set(float64(len(tNumbersSet)))
 				util.InnerPhoneNumbers.Unlock()
 			}
 		}
 	}()
 }
 
-// func QueueManager(wg *sync.WaitGroup, queueTransport <-chan chan gami.Message, finishChan <-chan struct{},
-// 	ticker *time.Ticker) {
-// 	glog.Infoln("Initiating QueueManager...")
-// 	for {
-// 		select {
-// 		case <-finishChan:
-// 			glog.Warningln("Finishing QueueManager...")
-// 			ticker.Stop()
-// 			wg.Done()
-// 			return
-// 		case <-ticker.C:
-// 			util.InnerPhoneNumbers.RLock()
-// 			glog.Infoln("<<< MANAGING QUEUES...")
-// 			// Send queue status to AMI
-// 			if err := ami.QueueStatus(); err != nil {
-// 				glog.Errorln(err)
-// 				return
-// 			}
-// 			// and wait for channel with active queues from asterisk
-// 			activeQueuesChan := <-queueTransport
-// 			// sort active queues for each number per country
-// 			queuesNumberMap := util.GetActiveQueuesMap(activeQueuesChan)
-// 			for countryCode, settings := range conf.GetConf().Agencies {
-// 				tqs := queuesNumberMap[countryCode]
-// 				numbersState := make(model.Dict)
-// 				// For each inner number get its static queue from asterisk db
-// 				for number, _ := range util.InnerPhoneNumbers.NumbersMap[countryCode] {
-// 					staticQueue, err := ami.GetStaticQueue(number)
-// 					if err != nil {
-// 						// if there is no static queue for number - some problem with it, skip
-// 						continue
-// 					}
-// 					staticQueue = strings.Split(staticQueue, "\n")[0]
-// 					// if there is no active queues for such number, then its not available
-// 					if _, ok := tqs[number]; !ok {
-// 						numbersState[number] = "not_available"
-// 					} else {
-// 						// if there are some, which are not its static queue and not general queue
-// 						// (same as static but without last digit)
-// 						// then number should be removed from them and still not available
-// 						status := "not_available"
-// 						for _, queue := range tqs[number] {
-// 							generalizedQueue := staticQueue[:len(staticQueue)-1]
-// 							if staticQueue == queue || generalizedQueue == queue {
-// 								status = "available"
-// 							} else {
-// 								_, err := ami.RemoveFromQueue(queue, countryCode, number)
-// 								if err != nil {
-// 									glog.Errorln(err, number)
-// 								}
-// 							}
-// 						}
-// 						numbersState[number] = status
-// 					}
-// 				}
-// 				glog.Infoln("NUMBERS STATE", numbersState)
-//
-// 				url := conf.GetConf().GetApi(countryCode, "save_company_queues_states")
-// 				payload, _ := json.Marshal(numbersState)
-// 				_, err := util.SendRequest(payload, url, "POST", settings.Secret,
-// 					settings.CompanyId)
-// 				if err != nil {
-// 					glog.Errorln(err, url)
-// 				}
-// 			}
-// 			util.InnerPhoneNumbers.RUnlock()
-// 		}
-// 	}
-// }
+// CampaignDialer dials numbers of active campaigns within their calling hours. Number
+// of simultaneous calls of campaign is limited by its max concurrent calls and by
+// number of available managers in its queue
+func CampaignDialer(ctx context.Context, wg *sync.WaitGroup, ticker *time.Ticker) {
+	glog.Infoln("Initiating CampaignDialer...")
+	wg.Add(1)
+	go func() {
+		defer func() {
+			glog.Warningln("Finishing CampaignDialer...")
+			ticker.Stop()
+			wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Events about some calls may be lost, so they are treated as not answered
+				stuckNumbers, err := db.GetDB().SelectStuckCampaignNumbers(conf.CAMPAIGN_DIAL_TIMEOUT)
+				if err != nil {
+					campaignLog.Error("Cannot select stuck numbers", logger.Fields{"error": err})
+				}
+				for _, id := range stuckNumbers {
+					db.GetDB().SetCampaignNumberResult(id, CALL_NO_ANSWER, db.NUMBER_FAILED, true)
+				}
+				db.GetDB().FinishCampaigns()
+
+				campaigns, err := db.GetDB().SelectActiveCampaigns()
+				if err != nil {
+					campaignLog.Error("Cannot select active campaigns", logger.Fields{"error": err})
+					continue
+				}
+				for _, campaign := range campaigns {
+					if err := dialCampaign(campaign); err != nil {
+						campaignLog.Error("Cannot dial campaign",
+							logger.Fields{"campaign_id": campaign.ID, "error": err})
+					}
+				}
+			}
+		}
+	}()
+}
+
+func dialCampaign(campaign db.Campaign) error {
+	now := time.Now().In(conf.GetConf().GetLocation(campaign.Country))
+	if !conf.GetConf().IsOpen(campaign.Country, now) {
+		return nil
+	}
+	if ok, err := conf.IsInHours(now, campaign.HoursFrom, campaign.HoursTo); !ok || err != nil {
+		return err
+	}
+
+	dialing := db.GetDB().CountDialingNumbers(campaign.ID)
+	free := campaign.MaxConcurrent - dialing
+	if campaign.Queue != "" {
+		summaries, err := ami.QueueSummary(campaign.Queue)
+		if err != nil {
+			return err
+		}
+		available := 0
+		for _, summary := range summaries {
+			available += summary.Available
+		}
+		if available-dialing < free {
+			free = available - dialing
+		}
+	}
+	if free <= 0 {
+		return nil
+	}
+
+	numbers, err := db.GetDB().TakeCampaignNumbers(campaign.ID, free)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		log := campaignLog.With(logger.Fields{"campaign_id": campaign.ID, "number_id": number.ID,
+			"phone_number": number.PhoneNumber, "country": campaign.Country})
+		log.Info("Dialing campaign number")
+		// Number id in caller id lets us find it when call events come
+		callerId := fmt.Sprintf("%s%d <CallMeBack>", CAMPAIGN_CALLER_ID_PREFIX, number.ID)
+		var resp gami.Message
+		var err error
+		if campaign.Queue != "" {
+			resp, err = ami.CallToQueue(number.PhoneNumber, campaign.Queue, callerId)
+		} else {
+			call := model.CallInQueue{PhoneNumber: number.PhoneNumber, Country: campaign.Country}
+			resp, err = ami.CallInQueue(call, callerId)
+		}
+		if err != nil || resp["Response"] == "Error" {
+			log.Error("Cannot dial campaign number", logger.Fields{"error": err, "response": resp})
+			db.GetDB().SetCampaignNumberResult(number.ID, CALL_FAILED, db.NUMBER_FAILED, true)
+		}
+	}
+	return nil
+}
+
+// AuditCleaner deletes audit records which are older than retention
+func AuditCleaner(ctx context.Context, wg *sync.WaitGroup, ticker *time.Ticker) {
+	glog.Infoln("Initiating AuditCleaner...")
+	wg.Add(1)
+	go func() {
+		defer func() {
+			glog.Warningln("Finishing AuditCleaner...")
+			ticker.Stop()
+			wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				res, err := db.GetDB().DeleteOldAuditRecords(conf.GetConf().GetAuditRetentionDays())
+				if err != nil {
+					glog.Errorln(err)
+				} else if count, _ := res.RowsAffected(); count != 0 {
+					glog.Infoln("<<< OLD AUDIT RECORDS DELETED", count)
+				}
+			}
+		}
+	}()
+}
+
+// ReloadOnSignal reloads configuration when dialer receives SIGHUP
+func ReloadOnSignal(ctx context.Context, wg *sync.WaitGroup) {
+	glog.Infoln("Initiating ReloadOnSignal...")
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	wg.Add(1)
+	go func() {
+		defer func() {
+			glog.Warningln("Finishing ReloadOnSignal...")
+			signal.Stop(sigChan)
+			wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigChan:
+				if err := conf.Reload(); err != nil {
+					glog.Errorln("Cannot reload configuration", err)
+				}
+			}
+		}
+	}()
+}
+
+// CallBackDialer dials callback requests which are due, as well as those which
+// should be retried after unsuccessful attempts
+func CallBackDialer(ctx context.Context, wg *sync.WaitGroup, ticker *time.Ticker) {
+	glog.Infoln("Initiating CallBackDialer...")
+	wg.Add(1)
+	go func() {
+		defer func() {
+			glog.Warningln("Finishing CallBackDialer...")
+			ticker.Stop()
+			wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Events about some calls may be lost, so they are treated as not answered
+				stuckCallBacks, err := db.GetDB().SelectStuckCallbacks(conf.CALLBACK_DIAL_TIMEOUT)
+				if err != nil {
+					callbackLog.Error("Cannot select stuck callbacks", logger.Fields{"error": err})
+				}
+				for _, id := range stuckCallBacks {
+					SaveCallBackResult(id, CALL_NO_ANSWER)
+				}
+
+				callbacks, err := db.GetDB().SelectDueCallbacks(conf.MAX_CALLBACKS_NUMBER)
+				if err != nil {
+					callbackLog.Error("Cannot select due callbacks", logger.Fields{"error": err})
+					continue
+				}
+				for _, callback := range callbacks {
+					if _, err := DialCallBack(callback); err != nil {
+						callbackLog.Error("Cannot dial callback",
+							logger.Fields{"callback_id": callback.ID, "error": err})
+					}
+				}
+			}
+		}
+	}()
+}
+
+// DialCallBack calls managers in callback queue and connects the one who answered
+// with the number of callback request. When the country is closed request stays pending
+func DialCallBack(callback db.Callback) (gami.Message, error) {
+	settings := conf.GetConf()
+	if settings.CallBackInBusinessHours && !settings.IsOpen(callback.Country, time.Now()) {
+		return gami.Message{"Message": "Callback is scheduled for business hours"}, nil
+	}
+	if ok, err := db.GetDB().DialCallback(callback.ID); err != nil || !ok {
+		return gami.Message{"Message": "Callback is already dialing"}, err
+	}
+
+	callbackLog.Info("Dialing callback", logger.Fields{"callback_id": callback.ID,
+		"phone_number": callback.PhoneNumber, "country": callback.Country})
+	call := model.CallInQueue{PhoneNumber: callback.PhoneNumber, Country: callback.Country}
+	resp, err := ami.CallInQueue(call, ami.CALLBACK_CALLER_ID)
+	if err != nil || resp["Response"] == "Error" {
+		SaveCallBackResult(callback.ID, CALL_FAILED)
+	}
+	return resp, err
+}
+
+// SaveCallBackResult saves result of callback attempt and sends new state
+// of callback request to portal
+func SaveCallBackResult(id int, result string) {
+	status, retry := getDialStatus(result)
+	// Failed originate most likely means problems with asterisk, so it is retried too
+	retry = retry || result == CALL_FAILED
+	log := callbackLog.With(logger.Fields{"callback_id": id, "result": result})
+	res, err := db.GetDB().SetCallbackResult(id, result, status, retry,
+		conf.GetConf().GetCallBackAttempts(), conf.GetConf().GetCallBackRetryInterval())
+	if err != nil {
+		log.Error("Cannot save callback result", logger.Fields{"error": err})
+		return
+	} else if count, _ := res.RowsAffected(); count != 1 {
+		return
+	}
+
+	callback, err := db.GetDB().GetCallback(id)
+	if err != nil {
+		log.Error("Cannot get callback", logger.Fields{"error": err})
+		return
+	}
+	log = log.With(logger.Fields{"phone_number": callback.PhoneNumber, "country": callback.Country,
+		"status": callback.Status})
+	log.Info("Callback result saved")
+	if conf.GetConf().CallBackStatusApi == "" {
+		return
+	}
+	resp, err := util.SendCallBackStatus(callback.ID, callback.PhoneNumber, callback.Status,
+		callback.LastResult, callback.Attempts, callback.Country)
+	if err != nil {
+		log.Error("Cannot send callback status", logger.Fields{"error": err})
+	} else {
+		log.Info("Callback status sent", logger.Fields{"response": resp})
+	}
+}
+
+// QueueAMI is the part of asterisk API used by QueueManager
+type QueueAMI interface {
+	QueueMembers(queue string) ([]model.QueueMember, error)
+	GetStaticQueue(number string) (string, error)
+	RemoveMember(queue, location string) (gami.Message, error)
+}
+
+type amiQueues struct{}
+
+func (amiQueues) QueueMembers(queue string) ([]model.QueueMember, error) {
+	return ami.QueueMembers(queue)
+}
+
+func (amiQueues) GetStaticQueue(number string) (string, error) {
+	return ami.GetStaticQueue(number)
+}
+
+func (amiQueues) RemoveMember(queue, location string) (gami.Message, error) {
+	return ami.RemoveMember(queue, location)
+}
+
+// QueueManager removes managers from queues which do not match their static queue
+// and sends fresh queues states to portals, so the portal works only with local data.
+// In dry run mode it only logs what would be done
+func QueueManager(ctx context.Context, wg *sync.WaitGroup, qa QueueAMI, dryRun bool,
+	ticker *time.Ticker) {
+	glog.Infoln("Initiating QueueManager...")
+	wg.Add(1)
+	go func() {
+		defer func() {
+			glog.Warningln("Finishing QueueManager...")
+			ticker.Stop()
+			wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				glog.Infoln("<<< MANAGING QUEUES...")
+				states, err := ManageQueues(qa, dryRun)
+				if err != nil {
+					glog.Errorln(err)
+					continue
+				}
+				for countryCode, numbersState := range states {
+					glog.Infoln("NUMBERS STATE", countryCode, numbersState)
+					if dryRun {
+						continue
+					}
+					settings := conf.GetConf().Agencies[countryCode]
+					url := conf.GetConf().GetApi(countryCode, "save_company_queues_states")
+					payload, _ := json.Marshal(numbersState)
+					_, err := util.SendRequest(payload, url, "POST", settings.Secret,
+						settings.CompanyId)
+					if err != nil {
+						glog.Errorln(err, url)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// ManageQueues returns states of inner numbers in queues per country. Number is
+// available only if it is in its static queue (from queues/u2q family of asterisk db)
+// or in general queue, which is the static one without last digit. Number is removed
+// from all other queues
+func ManageQueues(qa QueueAMI, dryRun bool) (map[string]model.Dict, error) {
+	members, err := qa.QueueMembers("")
+	if err != nil {
+		return nil, err
+	}
+	queuesNumberMap := util.GetActiveQueuesMap(members)
+
+	util.InnerPhoneNumbers.RLock()
+	countryNumbers := map[string][]string{}
+	for countryCode := range conf.GetConf().Agencies {
+		for number := range util.InnerPhoneNumbers.NumbersMap[countryCode] {
+			countryNumbers[countryCode] = append(countryNumbers[countryCode], number)
+		}
+	}
+	util.InnerPhoneNumbers.RUnlock()
+
+	states := map[string]model.Dict{}
+	staticQueues := map[string]string{}
+	removed := map[string]struct{}{}
+	for countryCode, numbers := range countryNumbers {
+		tqs := queuesNumberMap[countryCode]
+		numbersState := model.Dict{}
+		for _, number := range numbers {
+			staticQueue, ok := staticQueues[number]
+			if !ok {
+				staticQueue, err = qa.GetStaticQueue(number)
+				if err != nil {
+					// if there is no static queue for number - some problem with it, skip
+					continue
+				}
+				staticQueue = strings.Split(staticQueue, "\n")[0]
+				staticQueues[number] = staticQueue
+			}
+			if staticQueue == "" {
+				continue
+			}
+
+			status := "not_available"
+			generalizedQueue := staticQueue[:len(staticQueue)-1]
+			for _, member := range tqs[number] {
+				if staticQueue == member.Queue || generalizedQueue == member.Queue {
+					status = "available"
+					continue
+				}
+				// Duplicated number is present in several countries, but should be
+				// removed only once
+				key := member.Queue + "/" + member.Location
+				if _, ok := removed[key]; ok {
+					continue
+				}
+				removed[key] = struct{}{}
+				if dryRun {
+					glog.Infoln("DRY RUN | Would remove from queue", member.Queue, member.Location)
+					continue
+				}
+				if _, err := qa.RemoveMember(member.Queue, member.Location); err != nil {
+					glog.Errorln(err, number)
+				}
+			}
+			numbersState[number] = status
+		}
+		states[countryCode] = numbersState
+	}
+	return states, nil
+}
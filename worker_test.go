@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/warik/gami"
+
+	"github.com/warik/go-dialer/conf"
+	"github.com/warik/go-dialer/model"
+	"github.com/warik/go-dialer/util"
+)
+
+type fakeQueueAMI struct {
+	members      []model.QueueMember
+	staticQueues map[string]string
+	removed      []string
+}
+
+func (f *fakeQueueAMI) QueueMembers(queue string) ([]model.QueueMember, error) {
+	return f.members, nil
+}
+
+func (f *fakeQueueAMI) GetStaticQueue(number string) (string, error) {
+	return f.staticQueues[number], nil
+}
+
+func (f *fakeQueueAMI) RemoveMember(queue, location string) (gami.Message, error) {
+	f.removed = append(f.removed, queue+" "+location)
+	return gami.Message{"Response": "Success"}, nil
+}
+
+func TestManageQueues(t *testing.T) {
+	conf.SetConf(&conf.Configuration{
+		Agencies: map[string]model.CountrySettings{"ua": {}},
+	})
+	util.InnerPhoneNumbers.Lock()
+	util.InnerPhoneNumbers.NumbersMap = map[string]model.Set{
+		"ua": {"101": struct{}{}, "102": struct{}{}},
+	}
+	util.InnerPhoneNumbers.Unlock()
+
+	member := func(queue, location, number, membership string) model.QueueMember {
+		return model.QueueMember{Queue: queue, Location: location, InnerNumber: number,
+			Country: "ua", Membership: membership}
+	}
+	staticQueues := map[string]string{"101": "sales1", "102": "support1"}
+
+	tests := []struct {
+		name    string
+		members []model.QueueMember
+		dryRun  bool
+		removed []string
+		states  model.Dict
+	}{
+		{
+			name: "member of static and general queues is kept",
+			members: []model.QueueMember{
+				member("sales1", "SIP/101", "101", "dynamic"),
+				member("sales", "SIP/101", "101", "dynamic"),
+			},
+			states: model.Dict{"101": "available", "102": "not_available"},
+		},
+		{
+			name: "member of other queue is removed by its location",
+			members: []model.QueueMember{
+				member("sales1", "Local/101ua@agents", "101", "dynamic"),
+				member("support1", "Local/101ua@agents", "101", "dynamic"),
+				member("sales1", "SIP/102", "102", "dynamic"),
+			},
+			removed: []string{"sales1 SIP/102", "support1 Local/101ua@agents"},
+			states:  model.Dict{"101": "available", "102": "not_available"},
+		},
+		{
+			name: "static member is not removed",
+			members: []model.QueueMember{
+				member("sales1", "SIP/102", "102", "static"),
+			},
+			states: model.Dict{"101": "not_available", "102": "not_available"},
+		},
+		{
+			name: "dry run removes nothing",
+			members: []model.QueueMember{
+				member("support1", "Local/101ua@agents", "101", "dynamic"),
+			},
+			dryRun: true,
+			states: model.Dict{"101": "not_available", "102": "not_available"},
+		},
+	}
+	for _, tt := range tests {
+		qa := &fakeQueueAMI{members: tt.members, staticQueues: staticQueues}
+		states, err := ManageQueues(qa, tt.dryRun)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		sort.Strings(qa.removed)
+		if len(qa.removed) != 0 || len(tt.removed) != 0 {
+			if !reflect.DeepEqual(qa.removed, tt.removed) {
+				t.Errorf("%s: removed %v, want %v", tt.name, qa.removed, tt.removed)
+			}
+		}
+		if !reflect.DeepEqual(states["ua"], tt.states) {
+			t.Errorf("%s: states %v, want %v", tt.name, states["ua"], tt.states)
+		}
+	}
+}